@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_ALERTMANAGER_ALERT_NAME      = "ServerOffline"
+	DEFAULT_ALERTMANAGER_RESEND_INTERVAL = time.Minute
+	ALERTMANAGER_ALERTS_PATH             = "/api/v2/alerts"
+)
+
+var alertmanagerLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// An AlertmanagerAlert is the representation of an alert expected by
+// Alertmanager's API. Alerts without EndsAt are active until Alertmanager's
+// resolve_timeout has passed, so they have to be re-sent periodically.
+type AlertmanagerAlert struct {
+	Labels       map[string]string `json:"labels"`
+	Annotations  map[string]string `json:"annotations,omitempty"`
+	StartsAt     time.Time         `json:"startsAt"`
+	EndsAt       *time.Time        `json:"endsAt,omitempty"`
+	GeneratorURL string            `json:"generatorURL,omitempty"`
+}
+
+// The AlertmanagerNotifier pushes an alert for every offline server to a
+// Prometheus Alertmanager. Alerts are re-sent every resend interval until
+// the server is back online, which sets their end time.
+type AlertmanagerNotifier struct {
+	config AlertmanagerNotifierConfiguration
+	url    string
+	// alerts contains the active alerts by server name as well as resolved
+	// ones that could not be sent yet.
+	alerts map[string]*AlertmanagerAlert
+}
+
+func NewAlertmanagerNotifier(config AlertmanagerNotifierConfiguration) (*AlertmanagerNotifier, error) {
+	parsed, err := url.Parse(config.Url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("url %q is not an absolute HTTP(S) URL", config.Url)
+	}
+	for name := range config.Labels {
+		if !alertmanagerLabelName.MatchString(name) {
+			return nil, fmt.Errorf("invalid label name %q", name)
+		}
+	}
+	if config.ResendInterval < 0 {
+		return nil, fmt.Errorf("resendInterval must not be negative")
+	}
+	// Both the base URL of Alertmanager and the full endpoint are accepted.
+	alertsUrl := strings.TrimSuffix(config.Url, "/")
+	if !strings.HasSuffix(alertsUrl, ALERTMANAGER_ALERTS_PATH) {
+		alertsUrl += ALERTMANAGER_ALERTS_PATH
+	}
+	return &AlertmanagerNotifier{
+		config: config,
+		url:    alertsUrl,
+		alerts: make(map[string]*AlertmanagerAlert),
+	}, nil
+}
+
+func (n *AlertmanagerNotifier) Interval() time.Duration {
+	if n.config.ResendInterval == 0 {
+		return DEFAULT_ALERTMANAGER_RESEND_INTERVAL
+	}
+	return time.Duration(n.config.ResendInterval)
+}
+
+func (n *AlertmanagerNotifier) Notify(ctx context.Context, message Message) error {
+	switch message.Status {
+	case STATUS_OFFLINE:
+		n.alerts[message.ServerName] = n.newAlert(message)
+		return n.Tick(ctx)
+	case STATUS_ONLINE:
+		return n.Resolve(ctx, message)
+	}
+	return nil
+}
+
+// Resolve sends the server's alert with its end time.
+func (n *AlertmanagerNotifier) Resolve(ctx context.Context, message Message) error {
+	alert, found := n.alerts[message.ServerName]
+	if !found {
+		// The alert was never sent (e.g. because statusd has been
+		// restarted) and expires on its own.
+		return nil
+	}
+	endsAt := message.Time
+	if endsAt.IsZero() {
+		endsAt = time.Now()
+	}
+	alert.EndsAt = &endsAt
+	return n.Tick(ctx)
+}
+
+// Tick sends all known alerts. Resolved alerts are forgotten once they
+// have been sent successfully.
+func (n *AlertmanagerNotifier) Tick(ctx context.Context) error {
+	if len(n.alerts) == 0 {
+		return nil
+	}
+	names := make([]string, 0, len(n.alerts))
+	for name := range n.alerts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	alerts := make([]*AlertmanagerAlert, 0, len(names))
+	for _, name := range names {
+		alerts = append(alerts, n.alerts[name])
+	}
+	if _, err := postJSON(ctx, n.url, n.config.Headers, alerts); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if n.alerts[name].EndsAt != nil {
+			delete(n.alerts, name)
+		}
+	}
+	return nil
+}
+
+// newAlert derives the labels from the server's name, tags and the reason
+// for it being offline. Configured labels are added to all alerts.
+func (n *AlertmanagerNotifier) newAlert(message Message) *AlertmanagerAlert {
+	labels := make(map[string]string)
+	for name, value := range n.config.Labels {
+		labels[name] = value
+	}
+	labels["alertname"] = firstNonEmpty(n.config.AlertName, DEFAULT_ALERTMANAGER_ALERT_NAME)
+	labels["server"] = message.ServerName
+	if len(message.Tags) != 0 {
+		labels["tags"] = strings.Join(message.Tags, ",")
+	}
+	if message.Reason != "" {
+		labels["reason"] = message.Reason
+	}
+	annotations := map[string]string{
+		"summary":     message.Title(),
+		"description": message.Text(),
+	}
+	if message.Url != "" {
+		annotations["url"] = message.Url
+	}
+	startsAt := message.Time
+	if startsAt.IsZero() {
+		startsAt = time.Now()
+	}
+	return &AlertmanagerAlert{
+		Labels:       labels,
+		Annotations:  annotations,
+		StartsAt:     startsAt,
+		GeneratorURL: message.DashboardUrl,
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type alertmanagerRecorder struct {
+	lock     sync.Mutex
+	requests [][]AlertmanagerAlert
+	fail     bool
+}
+
+func (r *alertmanagerRecorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if req.URL.Path != ALERTMANAGER_ALERTS_PATH || r.fail {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	var alerts []AlertmanagerAlert
+	json.NewDecoder(req.Body).Decode(&alerts)
+	r.requests = append(r.requests, alerts)
+}
+
+func (r *alertmanagerRecorder) received() [][]AlertmanagerAlert {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([][]AlertmanagerAlert(nil), r.requests...)
+}
+
+func TestAlertmanagerNotifier(t *testing.T) {
+	recorder := &alertmanagerRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	notifier, err := NewAlertmanagerNotifier(AlertmanagerNotifierConfiguration{
+		Url:    server.URL + "/",
+		Labels: map[string]string{"team": "ops"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	offline := Message{ServerName: "web", Status: STATUS_OFFLINE, Reason: "timeout", Tags: []string{"prod", "eu"}}
+	if err := notifier.Notify(ctx, offline); err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	requests := recorder.received()
+	if len(requests) != 2 || len(requests[1]) != 1 {
+		t.Fatalf("Expected the alert to be re-sent, got %v", requests)
+	}
+	alert := requests[1][0]
+	expected := map[string]string{"alertname": DEFAULT_ALERTMANAGER_ALERT_NAME, "server": "web", "tags": "prod,eu", "reason": "timeout", "team": "ops"}
+	for name, value := range expected {
+		if alert.Labels[name] != value {
+			t.Errorf("Expected label %s to be %s, got %v", name, value, alert.Labels)
+		}
+	}
+	if alert.EndsAt != nil || alert.StartsAt.IsZero() {
+		t.Errorf("Expected an active alert, got %v", alert)
+	}
+
+	// A resolved alert is kept until it has been sent.
+	recorder.fail = true
+	if err := notifier.Notify(ctx, Message{ServerName: "web", Status: STATUS_ONLINE}); err == nil {
+		t.Error("Expected the resolved alert not to be sent")
+	}
+	recorder.fail = false
+	if err := notifier.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+	requests = recorder.received()
+	if resolved := requests[len(requests)-1]; len(resolved) != 1 || resolved[0].EndsAt == nil || resolved[0].Labels["reason"] != "timeout" {
+		t.Errorf("Expected the alert to be resolved with the same labels, got %v", resolved)
+	}
+	notifier.Tick(ctx)
+	if len(recorder.received()) != len(requests) {
+		t.Error("Expected resolved alerts to be forgotten")
+	}
+}
+
+func TestAlertmanagerNotifierResend(t *testing.T) {
+	recorder := &alertmanagerRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	notifier, _ := NewAlertmanagerNotifier(AlertmanagerNotifierConfiguration{
+		Url:            server.URL + ALERTMANAGER_ALERTS_PATH,
+		ResendInterval: Duration(10 * time.Millisecond),
+	})
+	d, _ := NewNotificationDispatcher(&Configuration{})
+	d.Add("alertmanager", notifier, serverFilter{})
+	d.Start()
+	d.Dispatch(Event{StatusUpdate: StatusUpdate{ServerName: "web", Status: STATUS_OFFLINE}})
+	deadline := time.Now().Add(time.Second)
+	for len(recorder.received()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	d.Close(time.Second)
+	if requests := recorder.received(); len(requests) < 3 {
+		t.Errorf("Expected the alert to be re-sent periodically, got %v", requests)
+	}
+}
+
+func TestNewAlertmanagerNotifierValidation(t *testing.T) {
+	for _, config := range []AlertmanagerNotifierConfiguration{
+		{},
+		{Url: "alertmanager:9093"},
+		{Url: "http://alertmanager:9093", Labels: map[string]string{"team-name": "ops"}},
+		{Url: "http://alertmanager:9093", ResendInterval: Duration(-time.Second)},
+	} {
+		if _, err := NewAlertmanagerNotifier(config); err == nil {
+			t.Errorf("Expected %v to be invalid", config)
+		}
+	}
+}
+
+func TestAlertmanagerNotifierSilentRecovery(t *testing.T) {
+	recorder := &alertmanagerRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	alertmanager, _ := NewAlertmanagerNotifier(AlertmanagerNotifierConfiguration{Url: server.URL})
+	others := &recordingNotifier{}
+	d, _ := NewNotificationDispatcher(&Configuration{})
+	d.Add("alertmanager", alertmanager, serverFilter{})
+	d.Add("others", others, serverFilter{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	doneGroup := &sync.WaitGroup{}
+	doneGroup.Add(1)
+	updates := make(chan StatusUpdate)
+	go StatusHandler(ctx, d, updates, doneGroup)
+	defer statusRegistryManager.RemoveStatus("am-silent")
+	updates <- StatusUpdate{ServerName: "am-silent", Status: STATUS_ONLINE}
+	updates <- StatusUpdate{ServerName: "am-silent", Status: STATUS_OFFLINE}
+	// The server recovers outside of its active hours.
+	updates <- StatusUpdate{ServerName: "am-silent", Status: STATUS_ONLINE, Silent: true}
+	cancel()
+	doneGroup.Wait()
+
+	if servers := others.servers(); len(servers) != 1 {
+		t.Errorf("Expected other notifiers to only receive the outage, got %v", servers)
+	}
+	requests := recorder.received()
+	if len(requests) != 2 || len(requests[1]) != 1 || requests[1][0].EndsAt == nil {
+		t.Errorf("Expected the alert to be resolved, got %v", requests)
+	}
+	if len(alertmanager.alerts) != 0 {
+		t.Errorf("Expected no active alerts, got %v", alertmanager.alerts)
+	}
+}
+
+func TestAlertmanagerNotifierOfflineOnStartup(t *testing.T) {
+	recorder := &alertmanagerRecorder{}
+	server := httptest.NewServer(recorder)
+	defer server.Close()
+	// The alert of a previous run is unknown to a freshly started notifier.
+	alertmanager, _ := NewAlertmanagerNotifier(AlertmanagerNotifierConfiguration{
+		Url:            server.URL,
+		ResendInterval: Duration(10 * time.Millisecond),
+	})
+	d, _ := NewNotificationDispatcher(&Configuration{})
+	d.Add("alertmanager", alertmanager, serverFilter{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	doneGroup := &sync.WaitGroup{}
+	doneGroup.Add(1)
+	updates := make(chan StatusUpdate)
+	go StatusHandler(ctx, d, updates, doneGroup)
+	defer statusRegistryManager.RemoveStatus("am-restarted")
+	defer statusRegistryManager.RemoveStatus("am-inactive")
+	updates <- StatusUpdate{ServerName: "am-restarted", Status: STATUS_OFFLINE}
+	updates <- StatusUpdate{ServerName: "am-inactive", Status: STATUS_OFFLINE, Silent: true}
+	deadline := time.Now().Add(time.Second)
+	for len(recorder.received()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	doneGroup.Wait()
+
+	requests := recorder.received()
+	if len(requests) < 3 {
+		t.Fatalf("Expected the alert to be re-sent periodically, got %v", requests)
+	}
+	for _, alerts := range requests {
+		if len(alerts) != 1 || alerts[0].Labels["server"] != "am-restarted" || alerts[0].EndsAt != nil {
+			t.Errorf("Expected only the active alert of am-restarted, got %v", alerts)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/zerok/statusd/Godeps/_workspace/src/github.com/gorilla/mux"
+)
+
+// The ServerApi allows 3rd parties to add, modify and remove servers while
+// statusd is running. Every change is persisted to the state file (if one
+// is configured) so that it survives a restart.
+type ServerApi struct {
+	pool      *ServerPool
+	token     string
+	stateFile string
+	// saveLock serializes writes to the state file.
+	saveLock sync.Mutex
+}
+
+func NewServerApi(pool *ServerPool, cfg ApiConfiguration) *ServerApi {
+	return &ServerApi{pool: pool, token: cfg.Token, stateFile: cfg.StateFile}
+}
+
+// Register adds the API's routes to the given router.
+func (a *ServerApi) Register(router *mux.Router) {
+	router.Path("/api/v1/servers/{name}").Methods("POST").HandlerFunc(a.authenticated(a.createServerHandler))
+	router.Path("/api/v1/servers/{name}").Methods("PUT").HandlerFunc(a.authenticated(a.updateServerHandler))
+	router.Path("/api/v1/servers/{name}").Methods("DELETE").HandlerFunc(a.authenticated(a.deleteServerHandler))
+}
+
+// authenticated only passes requests to the wrapped handler that carry the
+// configured token as bearer token.
+func (a *ServerApi) authenticated(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if a.token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			apiError(w, http.StatusUnauthorized, "Invalid or missing token")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func (a *ServerApi) createServerHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	serverConfig, err := decodeServerConfiguration(a.pool.config, r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := a.pool.Add(name, SOURCE_API, serverConfig); err != nil {
+		apiError(w, http.StatusConflict, err.Error())
+		return
+	}
+	log.Printf("Server %s added through the API\n", name)
+	a.persist()
+	Render.JSON(w, http.StatusCreated, serverConfig)
+}
+
+func (a *ServerApi) updateServerHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	serverConfig, err := decodeServerConfiguration(a.pool.config, r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := a.pool.Set(name, SOURCE_API, serverConfig); err != nil {
+		apiError(w, http.StatusConflict, err.Error())
+		return
+	}
+	log.Printf("Server %s updated through the API\n", name)
+	a.persist()
+	Render.JSON(w, http.StatusOK, serverConfig)
+}
+
+func (a *ServerApi) deleteServerHandler(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	found, err := a.pool.Remove(name, SOURCE_API)
+	if !found {
+		apiError(w, http.StatusNotFound, fmt.Sprintf("Server %s not found", name))
+		return
+	}
+	if err != nil {
+		apiError(w, http.StatusConflict, err.Error())
+		return
+	}
+	log.Printf("Server %s removed through the API\n", name)
+	a.persist()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// persist writes all servers managed through the API to the state file.
+// Failures are only logged as the change itself has already been applied.
+func (a *ServerApi) persist() {
+	if a.stateFile == "" {
+		return
+	}
+	a.saveLock.Lock()
+	defer a.saveLock.Unlock()
+	state := ServerState{Servers: a.pool.Servers(SOURCE_API)}
+	if err := state.Save(a.stateFile); err != nil {
+		log.Printf("Failed to write state file %s: %s\n", a.stateFile, err.Error())
+	}
+}
+
+func decodeServerConfiguration(config *Configuration, r *http.Request) (ServerConfiguration, error) {
+	var serverConfig ServerConfiguration
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&serverConfig); err != nil {
+		return serverConfig, fmt.Errorf("Invalid request body: %s", err.Error())
+	}
+	if messages := validateServerConfiguration(config, serverConfig); len(messages) != 0 {
+		return serverConfig, fmt.Errorf("Invalid server configuration: %s", strings.Join(messages, ", "))
+	}
+	return serverConfig, nil
+}
+
+func apiError(w http.ResponseWriter, code int, message string) {
+	Render.JSON(w, code, struct {
+		Error string `json:"error"`
+	}{message})
+}
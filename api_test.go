@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zerok/statusd/Godeps/_workspace/src/github.com/gorilla/mux"
+)
+
+func newTestApi(t *testing.T) (*ServerApi, *ServerPool, *mux.Router) {
+	ctx, cancel := context.WithCancel(context.Background())
+	doneGroup := &sync.WaitGroup{}
+	updates := make(chan StatusUpdate, 10)
+	pool := NewServerPool(ctx, &Configuration{}, updates, doneGroup)
+	t.Cleanup(func() {
+		cancel()
+		doneGroup.Wait()
+	})
+	api := NewServerApi(pool, ApiConfiguration{Token: "secret", StateFile: filepath.Join(t.TempDir(), "state.yaml")})
+	router := mux.NewRouter()
+	api.Register(router)
+	return api, pool, router
+}
+
+func apiRequest(router http.Handler, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestServerApiRequiresToken(t *testing.T) {
+	_, pool, router := newTestApi(t)
+	body := `{"isAliveUrl": "http://localhost:1/"}`
+	if rec := apiRequest(router, "POST", "/api/v1/servers/review", "", body); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without token, got %d", rec.Code)
+	}
+	if rec := apiRequest(router, "POST", "/api/v1/servers/review", "wrong", body); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 with a wrong token, got %d", rec.Code)
+	}
+	if pool.Len() != 0 {
+		t.Error("Unauthenticated requests must not change the pool")
+	}
+}
+
+func TestServerApiLifecycle(t *testing.T) {
+	api, pool, router := newTestApi(t)
+	pool.Add("static", SOURCE_CONFIG, ServerConfiguration{IsAliveUrl: "http://localhost:1/"})
+
+	if rec := apiRequest(router, "POST", "/api/v1/servers/review", "secret", `{"isAliveUrl": "ftp://example.com"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid URL, got %d", rec.Code)
+	}
+	if rec := apiRequest(router, "POST", "/api/v1/servers/review", "secret", `{"isAliveUrl": "http://localhost:1/", "timout": "1s"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown key, got %d", rec.Code)
+	}
+	if rec := apiRequest(router, "POST", "/api/v1/servers/review", "secret", `{"isAliveUrl": "http://localhost:1/", "delay": 5}`); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := apiRequest(router, "POST", "/api/v1/servers/review", "secret", `{"isAliveUrl": "http://localhost:1/"}`); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a duplicate server, got %d", rec.Code)
+	}
+	if rec := apiRequest(router, "PUT", "/api/v1/servers/static", "secret", `{"isAliveUrl": "http://localhost:1/"}`); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 when modifying a configured server, got %d", rec.Code)
+	}
+	if rec := apiRequest(router, "DELETE", "/api/v1/servers/static", "secret", ""); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 when removing a configured server, got %d", rec.Code)
+	}
+	if _, source, found := pool.Get("static"); !found || source != SOURCE_CONFIG {
+		t.Error("Configured servers must not be changed through the API")
+	}
+	if rec := apiRequest(router, "PUT", "/api/v1/servers/review", "secret", `{"isAliveUrl": "http://localhost:2/"}`); rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d", rec.Code)
+	}
+
+	state, err := LoadServerState(api.stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Servers) != 1 || state.Servers["review"].IsAliveUrl != "http://localhost:2/" {
+		t.Errorf("Unexpected state after update: %v", state.Servers)
+	}
+
+	if rec := apiRequest(router, "DELETE", "/api/v1/servers/review", "secret", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rec.Code)
+	}
+	if rec := apiRequest(router, "DELETE", "/api/v1/servers/review", "secret", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rec.Code)
+	}
+	state, err = LoadServerState(api.stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Servers) != 0 {
+		t.Errorf("Expected the state file to be empty, got %v", state.Servers)
+	}
+}
+
+func TestServerStateRestore(t *testing.T) {
+	_, pool, _ := newTestApi(t)
+	pool.Add("restore-static", SOURCE_CONFIG, ServerConfiguration{IsAliveUrl: "http://localhost:1/"})
+	defer statusRegistryManager.RemoveStatus("restore-static")
+	defer statusRegistryManager.RemoveStatus("restore-valid")
+	state := &ServerState{Servers: map[string]ServerConfiguration{
+		"restore-valid":   {IsAliveUrl: "http://localhost:1/valid"},
+		"restore-invalid": {IsAliveUrl: "ftp://example.com"},
+		"restore-static":  {IsAliveUrl: "http://localhost:1/api"},
+	}}
+	state.Restore(pool)
+	if _, source, found := pool.Get("restore-valid"); !found || source != SOURCE_API {
+		t.Error("Expected valid servers to be restored")
+	}
+	if _, _, found := pool.Get("restore-invalid"); found {
+		t.Error("Expected invalid servers to be ignored")
+	}
+	if config, source, _ := pool.Get("restore-static"); source != SOURCE_CONFIG || config.IsAliveUrl != "http://localhost:1/" {
+		t.Error("Expected configured servers to be kept")
+	}
+}
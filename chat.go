@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// This file contains the notifiers for chat services other than Slack. They
+// all render the same information as the SlackNotifier.
+
+// checkUrl makes sure that the URL of the given setting is absolute and
+// uses one of the given schemes.
+func checkUrl(setting, rawUrl string, schemes ...string) error {
+	parsed, err := url.Parse(rawUrl)
+	if err == nil && parsed.Host != "" {
+		for _, scheme := range schemes {
+			if parsed.Scheme == scheme {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("%s %q is not an absolute %s URL", setting, rawUrl, strings.ToUpper(strings.Join(schemes, "/")))
+}
+
+type TeamsPayload struct {
+	Type        string            `json:"type"`
+	Attachments []TeamsAttachment `json:"attachments"`
+}
+
+type TeamsAttachment struct {
+	ContentType string    `json:"contentType"`
+	Content     TeamsCard `json:"content"`
+}
+
+type TeamsCard struct {
+	Schema  string        `json:"$schema"`
+	Type    string        `json:"type"`
+	Version string        `json:"version"`
+	Body    []TeamsBlock  `json:"body"`
+	Actions []TeamsAction `json:"actions,omitempty"`
+}
+
+type TeamsBlock struct {
+	Type   string      `json:"type"`
+	Text   string      `json:"text,omitempty"`
+	Weight string      `json:"weight,omitempty"`
+	Size   string      `json:"size,omitempty"`
+	Color  string      `json:"color,omitempty"`
+	Wrap   bool        `json:"wrap,omitempty"`
+	Facts  []TeamsFact `json:"facts,omitempty"`
+}
+
+type TeamsFact struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+type TeamsAction struct {
+	Type  string `json:"type"`
+	Title string `json:"title"`
+	Url   string `json:"url"`
+}
+
+// The TeamsNotifier posts Adaptive Cards to a Microsoft Teams webhook.
+type TeamsNotifier struct {
+	config TeamsNotifierConfiguration
+}
+
+func NewTeamsNotifier(config TeamsNotifierConfiguration) (*TeamsNotifier, error) {
+	if err := checkUrl("webhookUrl", config.WebhookUrl, "https"); err != nil {
+		return nil, err
+	}
+	return &TeamsNotifier{config: config}, nil
+}
+
+func (n *TeamsNotifier) Notify(ctx context.Context, message Message) error {
+	_, err := postJSON(ctx, n.config.WebhookUrl, nil, buildTeamsPayload(message))
+	return err
+}
+
+func buildTeamsPayload(message Message) TeamsPayload {
+	color := "Good"
+	if !message.Online() {
+		color = "Attention"
+	}
+	card := TeamsCard{
+		Schema:  "http://adaptivecards.io/schemas/adaptive-card.json",
+		Type:    "AdaptiveCard",
+		Version: "1.4",
+		Body: []TeamsBlock{{
+			Type:   "TextBlock",
+			Text:   message.Title(),
+			Weight: "Bolder",
+			Size:   "Medium",
+			Color:  color,
+			Wrap:   true,
+		}},
+	}
+	var facts []TeamsFact
+	for _, field := range message.Fields() {
+		facts = append(facts, TeamsFact{field[0], field[1]})
+	}
+	if len(facts) != 0 {
+		card.Body = append(card.Body, TeamsBlock{Type: "FactSet", Facts: facts})
+	}
+	if message.DashboardUrl != "" {
+		card.Actions = []TeamsAction{{"Action.OpenUrl", "Open dashboard", message.DashboardUrl}}
+	}
+	return TeamsPayload{
+		Type:        "message",
+		Attachments: []TeamsAttachment{{"application/vnd.microsoft.card.adaptive", card}},
+	}
+}
+
+type DiscordPayload struct {
+	Username  string         `json:"username,omitempty"`
+	AvatarUrl string         `json:"avatar_url,omitempty"`
+	Embeds    []DiscordEmbed `json:"embeds"`
+}
+
+type DiscordEmbed struct {
+	Title     string         `json:"title"`
+	Url       string         `json:"url,omitempty"`
+	Color     int            `json:"color"`
+	Fields    []DiscordField `json:"fields,omitempty"`
+	Timestamp string         `json:"timestamp,omitempty"`
+}
+
+type DiscordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+// The DiscordNotifier posts embeds to a Discord webhook.
+type DiscordNotifier struct {
+	config DiscordNotifierConfiguration
+}
+
+func NewDiscordNotifier(config DiscordNotifierConfiguration) (*DiscordNotifier, error) {
+	if err := checkUrl("webhookUrl", config.WebhookUrl, "https"); err != nil {
+		return nil, err
+	}
+	return &DiscordNotifier{config: config}, nil
+}
+
+func (n *DiscordNotifier) Notify(ctx context.Context, message Message) error {
+	_, err := postJSON(ctx, n.config.WebhookUrl, nil, buildDiscordPayload(message, n.config))
+	return err
+}
+
+func buildDiscordPayload(message Message, cfg DiscordNotifierConfiguration) DiscordPayload {
+	embed := DiscordEmbed{
+		Title: message.Title(),
+		Url:   message.DashboardUrl,
+		Color: colorValue(SLACK_COLOR_ONLINE),
+	}
+	if !message.Online() {
+		embed.Color = colorValue(SLACK_COLOR_OFFLINE)
+	}
+	if !message.Time.IsZero() {
+		embed.Timestamp = message.Time.Format(time.RFC3339)
+	}
+	for _, field := range message.Fields() {
+		// The time is already shown through the timestamp.
+		if field[0] != "Time" {
+			embed.Fields = append(embed.Fields, DiscordField{field[0], field[1], field[0] != "Reason"})
+		}
+	}
+	return DiscordPayload{
+		Username:  firstNonEmpty(cfg.Username, DEFAULT_SLACK_USERNAME),
+		AvatarUrl: cfg.AvatarUrl,
+		Embeds:    []DiscordEmbed{embed},
+	}
+}
+
+// colorValue converts a color like "#2eb886" to its numeric value.
+func colorValue(color string) int {
+	value, _ := strconv.ParseInt(strings.TrimPrefix(color, "#"), 16, 32)
+	return int(value)
+}
+
+type MattermostPayload struct {
+	Text        string                 `json:"text"`
+	Channel     string                 `json:"channel,omitempty"`
+	Username    string                 `json:"username,omitempty"`
+	IconUrl     string                 `json:"icon_url,omitempty"`
+	Attachments []MattermostAttachment `json:"attachments,omitempty"`
+}
+
+type MattermostAttachment struct {
+	Fallback  string            `json:"fallback"`
+	Color     string            `json:"color"`
+	Title     string            `json:"title"`
+	TitleLink string            `json:"title_link,omitempty"`
+	Fields    []MattermostField `json:"fields,omitempty"`
+}
+
+type MattermostField struct {
+	Short bool   `json:"short"`
+	Title string `json:"title"`
+	Value string `json:"value"`
+}
+
+// The MattermostNotifier posts to Mattermost's Slack-compatible incoming
+// webhooks. Mattermost doesn't support Block Kit, so the details are sent
+// as attachment fields.
+type MattermostNotifier struct {
+	config MattermostNotifierConfiguration
+}
+
+func NewMattermostNotifier(config MattermostNotifierConfiguration) (*MattermostNotifier, error) {
+	if err := checkUrl("webhookUrl", config.WebhookUrl, "http", "https"); err != nil {
+		return nil, err
+	}
+	return &MattermostNotifier{config: config}, nil
+}
+
+func (n *MattermostNotifier) Notify(ctx context.Context, message Message) error {
+	channels := n.config.Channels
+	if len(channels) == 0 {
+		// The webhook posts to its own channel.
+		channels = []string{""}
+	}
+	for _, channel := range channels {
+		if _, err := postJSON(ctx, n.config.WebhookUrl, nil, buildMattermostPayload(message, channel, n.config)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func buildMattermostPayload(message Message, channel string, cfg MattermostNotifierConfiguration) MattermostPayload {
+	attachment := MattermostAttachment{
+		Fallback:  message.Text(),
+		Color:     SLACK_COLOR_ONLINE,
+		Title:     message.Title(),
+		TitleLink: message.DashboardUrl,
+	}
+	if !message.Online() {
+		attachment.Color = SLACK_COLOR_OFFLINE
+	}
+	for _, field := range message.Fields() {
+		attachment.Fields = append(attachment.Fields, MattermostField{field[0] != "Reason", field[0], field[1]})
+	}
+	return MattermostPayload{
+		Channel:     channel,
+		Username:    firstNonEmpty(cfg.Username, DEFAULT_SLACK_USERNAME),
+		IconUrl:     cfg.IconUrl,
+		Attachments: []MattermostAttachment{attachment},
+	}
+}
+
+// formatHtmlMessage renders the message using basic HTML that is supported
+// by both Telegram and Matrix.
+func formatHtmlMessage(message Message, lineBreak string) string {
+	lines := []string{fmt.Sprintf("<b>%s</b>", html.EscapeString(message.Title()))}
+	for _, field := range message.Fields() {
+		value := html.EscapeString(field[1])
+		if field[0] == "URL" {
+			value = fmt.Sprintf(`<a href="%s">%s</a>`, value, value)
+		}
+		lines = append(lines, fmt.Sprintf("<b>%s:</b> %s", html.EscapeString(field[0]), value))
+	}
+	if message.DashboardUrl != "" {
+		lines = append(lines, fmt.Sprintf(`<a href="%s">Open dashboard</a>`, html.EscapeString(message.DashboardUrl)))
+	}
+	return strings.Join(lines, lineBreak)
+}
+
+// formatTextMessage renders the message as plain text.
+func formatTextMessage(message Message) string {
+	lines := []string{message.Title()}
+	for _, field := range message.Fields() {
+		lines = append(lines, field[0]+": "+field[1])
+	}
+	if message.DashboardUrl != "" {
+		lines = append(lines, "Dashboard: "+message.DashboardUrl)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// telegramApiUrl is the address of the Bot API. It can be replaced in
+// tests.
+var telegramApiUrl = "https://api.telegram.org"
+
+type TelegramPayload struct {
+	ChatId                string `json:"chat_id"`
+	Text                  string `json:"text"`
+	ParseMode             string `json:"parse_mode"`
+	DisableWebPagePreview bool   `json:"disable_web_page_preview"`
+}
+
+// The TelegramNotifier sends messages to a chat through the Bot API.
+type TelegramNotifier struct {
+	config TelegramNotifierConfiguration
+}
+
+func NewTelegramNotifier(config TelegramNotifierConfiguration) (*TelegramNotifier, error) {
+	if config.BotToken == "" || config.ChatId == "" {
+		return nil, fmt.Errorf("telegram notifiers require a botToken and a chatId")
+	}
+	return &TelegramNotifier{config: config}, nil
+}
+
+func (n *TelegramNotifier) Notify(ctx context.Context, message Message) error {
+	payload := TelegramPayload{
+		ChatId:                n.config.ChatId,
+		Text:                  formatHtmlMessage(message, "\n"),
+		ParseMode:             "HTML",
+		DisableWebPagePreview: true,
+	}
+	body, err := postJSON(ctx, fmt.Sprintf("%s/bot%s/sendMessage", telegramApiUrl, n.config.BotToken), nil, payload)
+	if err != nil {
+		// Errors of the HTTP client contain the URL and thereby the token.
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("Telegram notification failed: %s", err.Error())
+	}
+	var response struct {
+		Ok          bool   `json:"ok"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return err
+	}
+	if !response.Ok {
+		return fmt.Errorf("sendMessage returned %s", response.Description)
+	}
+	return nil
+}
+
+type MatrixMessage struct {
+	MsgType       string `json:"msgtype"`
+	Body          string `json:"body"`
+	Format        string `json:"format"`
+	FormattedBody string `json:"formatted_body"`
+}
+
+// matrixTransactions makes the transaction IDs of Matrix messages unique.
+var matrixTransactions uint64
+
+// The MatrixNotifier sends notices to a room through the client-server API
+// of a Matrix homeserver.
+type MatrixNotifier struct {
+	config MatrixNotifierConfiguration
+}
+
+func NewMatrixNotifier(config MatrixNotifierConfiguration) (*MatrixNotifier, error) {
+	if err := checkUrl("homeserverUrl", config.HomeserverUrl, "http", "https"); err != nil {
+		return nil, err
+	}
+	if config.RoomId == "" || config.AccessToken == "" {
+		return nil, fmt.Errorf("matrix notifiers require a roomId and an accessToken")
+	}
+	return &MatrixNotifier{config: config}, nil
+}
+
+func (n *MatrixNotifier) Notify(ctx context.Context, message Message) error {
+	transactionId := fmt.Sprintf("statusd-%d-%d", time.Now().UnixNano(), atomic.AddUint64(&matrixTransactions, 1))
+	endpoint := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(n.config.HomeserverUrl, "/"), url.PathEscape(n.config.RoomId), transactionId)
+	payload := MatrixMessage{
+		MsgType:       "m.notice",
+		Body:          formatTextMessage(message),
+		Format:        "org.matrix.custom.html",
+		FormattedBody: formatHtmlMessage(message, "<br>"),
+	}
+	_, err := sendJSON(ctx, "PUT", endpoint, map[string]string{"Authorization": "Bearer " + n.config.AccessToken}, payload)
+	return err
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var chatTestMessage = Message{
+	ServerName:   "web",
+	Status:       STATUS_OFFLINE,
+	Reason:       "timeout",
+	Url:          "http://web.example.com/",
+	Time:         time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC),
+	DashboardUrl: "https://status.example.com/?servers=web",
+}
+
+// postToChatServer sends the test message through the notifier created by
+// newNotifier and returns the bodies of all requests.
+func postToChatServer(t *testing.T, newNotifier func(url string) (Notifier, error)) []json.RawMessage {
+	var bodies []json.RawMessage
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, data)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+	defer func(client *http.Client) { notificationClient = client }(notificationClient)
+	notificationClient = server.Client()
+	notifier, err := newNotifier(server.URL + "/hooks/abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(context.Background(), chatTestMessage); err != nil {
+		t.Fatal(err)
+	}
+	return bodies
+}
+
+func TestTeamsNotifier(t *testing.T) {
+	bodies := postToChatServer(t, func(url string) (Notifier, error) {
+		return NewTeamsNotifier(TeamsNotifierConfiguration{WebhookUrl: url})
+	})
+	var payload TeamsPayload
+	json.Unmarshal(bodies[0], &payload)
+	if len(payload.Attachments) != 1 || payload.Attachments[0].ContentType != "application/vnd.microsoft.card.adaptive" {
+		t.Fatalf("Expected an Adaptive Card, got %v", payload)
+	}
+	card := payload.Attachments[0].Content
+	if card.Type != "AdaptiveCard" || len(card.Body) != 2 || card.Body[0].Text != "web is now offline" || card.Body[0].Color != "Attention" {
+		t.Errorf("Unexpected card %v", card)
+	}
+	if facts := card.Body[1].Facts; len(facts) == 0 || facts[0] != (TeamsFact{"Reason", "timeout"}) {
+		t.Errorf("Unexpected facts %v", facts)
+	}
+	if len(card.Actions) != 1 || card.Actions[0].Url != chatTestMessage.DashboardUrl {
+		t.Errorf("Expected a link to the dashboard, got %v", card.Actions)
+	}
+}
+
+func TestDiscordNotifier(t *testing.T) {
+	bodies := postToChatServer(t, func(url string) (Notifier, error) {
+		return NewDiscordNotifier(DiscordNotifierConfiguration{WebhookUrl: url, Username: "Monitor"})
+	})
+	var payload DiscordPayload
+	json.Unmarshal(bodies[0], &payload)
+	if payload.Username != "Monitor" || len(payload.Embeds) != 1 {
+		t.Fatalf("Unexpected payload %v", payload)
+	}
+	embed := payload.Embeds[0]
+	if embed.Title != "web is now offline" || embed.Color != 0xd50200 || embed.Url != chatTestMessage.DashboardUrl || embed.Timestamp != "2016-01-02T03:04:05Z" {
+		t.Errorf("Unexpected embed %v", embed)
+	}
+	if len(embed.Fields) != 2 || embed.Fields[0] != (DiscordField{"Reason", "timeout", false}) || embed.Fields[1] != (DiscordField{"URL", chatTestMessage.Url, true}) {
+		t.Errorf("Unexpected fields %v", embed.Fields)
+	}
+}
+
+func TestMattermostNotifier(t *testing.T) {
+	bodies := postToChatServer(t, func(url string) (Notifier, error) {
+		return NewMattermostNotifier(MattermostNotifierConfiguration{WebhookUrl: url, Channels: []string{"ops", "town-square"}})
+	})
+	var payload MattermostPayload
+	var channels []string
+	for _, body := range bodies {
+		json.Unmarshal(body, &payload)
+		channels = append(channels, payload.Channel)
+	}
+	if len(channels) != 2 || channels[0] != "ops" || channels[1] != "town-square" {
+		t.Errorf("Expected a post per channel, got %v", channels)
+	}
+	if payload.Username != DEFAULT_SLACK_USERNAME || len(payload.Attachments) != 1 {
+		t.Fatalf("Unexpected payload %v", payload)
+	}
+	attachment := payload.Attachments[0]
+	if attachment.Color != SLACK_COLOR_OFFLINE || attachment.Title != "web is now offline" || attachment.TitleLink != chatTestMessage.DashboardUrl {
+		t.Errorf("Unexpected attachment %v", attachment)
+	}
+	if attachment.Fields[0] != (MattermostField{false, "Reason", "timeout"}) {
+		t.Errorf("Unexpected fields %v", attachment.Fields)
+	}
+}
+
+func TestChatNotifierValidation(t *testing.T) {
+	if _, err := NewTeamsNotifier(TeamsNotifierConfiguration{WebhookUrl: "http://example.com/"}); err == nil {
+		t.Error("Expected Teams webhooks to require HTTPS")
+	}
+	if _, err := NewDiscordNotifier(DiscordNotifierConfiguration{}); err == nil {
+		t.Error("Expected Discord notifiers to require a webhook")
+	}
+	if _, err := NewMattermostNotifier(MattermostNotifierConfiguration{WebhookUrl: "/hooks/abc"}); err == nil {
+		t.Error("Expected Mattermost webhooks to be absolute")
+	}
+	if _, err := NewMattermostNotifier(MattermostNotifierConfiguration{WebhookUrl: "http://mattermost:8065/hooks/abc"}); err != nil {
+		t.Error(err)
+	}
+	if _, err := NewTelegramNotifier(TelegramNotifierConfiguration{BotToken: "123:abc"}); err == nil {
+		t.Error("Expected Telegram notifiers to require a chat")
+	}
+	if _, err := NewMatrixNotifier(MatrixNotifierConfiguration{HomeserverUrl: "matrix.org", RoomId: "!room:matrix.org", AccessToken: "secret"}); err == nil {
+		t.Error("Expected Matrix homeservers to be absolute")
+	}
+}
+
+func TestTelegramNotifier(t *testing.T) {
+	var path string
+	var payload TelegramPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload.ChatId == "-1" {
+			w.Write([]byte(`{"ok": false, "description": "Bad Request: chat not found"}`))
+			return
+		}
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer server.Close()
+	defer func(url string) { telegramApiUrl = url }(telegramApiUrl)
+	telegramApiUrl = server.URL
+
+	notifier, err := NewTelegramNotifier(TelegramNotifierConfiguration{BotToken: "123:abc", ChatId: "@ops"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := chatTestMessage
+	message.Reason = "<timeout>"
+	if err := notifier.Notify(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/bot123:abc/sendMessage" || payload.ChatId != "@ops" || payload.ParseMode != "HTML" {
+		t.Errorf("Unexpected request to %s: %v", path, payload)
+	}
+	expected := "<b>web is now offline</b>\n<b>Reason:</b> &lt;timeout&gt;\n<b>URL:</b> <a href=\"http://web.example.com/\">http://web.example.com/</a>"
+	if !strings.HasPrefix(payload.Text, expected) || !strings.HasSuffix(payload.Text, `<a href="https://status.example.com/?servers=web">Open dashboard</a>`) {
+		t.Errorf("Unexpected text %s", payload.Text)
+	}
+
+	notifier, _ = NewTelegramNotifier(TelegramNotifierConfiguration{BotToken: "123:abc", ChatId: "-1"})
+	if err := notifier.Notify(context.Background(), message); err == nil || !strings.Contains(err.Error(), "chat not found") {
+		t.Errorf("Expected the API error to be returned, got %v", err)
+	}
+}
+
+func TestMatrixNotifier(t *testing.T) {
+	var requests []*http.Request
+	var message MatrixMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r)
+		json.NewDecoder(r.Body).Decode(&message)
+		w.Write([]byte(`{"event_id": "$1"}`))
+	}))
+	defer server.Close()
+
+	notifier, err := NewMatrixNotifier(MatrixNotifierConfiguration{HomeserverUrl: server.URL + "/", RoomId: "!room:example.org", AccessToken: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := notifier.Notify(context.Background(), chatTestMessage); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prefix := "/_matrix/client/v3/rooms/!room:example.org/send/m.room.message/"
+	request := requests[0]
+	if request.Method != "PUT" || !strings.HasPrefix(request.URL.Path, prefix) || request.Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("Unexpected request %s %s", request.Method, request.URL.Path)
+	}
+	if requests[0].URL.Path == requests[1].URL.Path {
+		t.Error("Expected every message to use a new transaction ID")
+	}
+	if message.MsgType != "m.notice" || message.Format != "org.matrix.custom.html" || !strings.HasPrefix(message.Body, "web is now offline\nReason: timeout\n") {
+		t.Errorf("Unexpected message %v", message)
+	}
+	if !strings.HasPrefix(message.FormattedBody, "<b>web is now offline</b><br><b>Reason:</b> timeout<br>") {
+		t.Errorf("Unexpected formatted body %s", message.FormattedBody)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// checkTransport is shared by all checks so that connections can be reused
+// even with thousands of servers.
+var checkTransport = &http.Transport{
+	TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+	MaxIdleConnsPerHost: 2,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+// A ServerCheck is the Job responsible for checking a single server and
+// reporting any status changes through the statusUpdateChannel. The server
+// configuration is expected to have all defaults resolved (see
+// Configuration.ResolveServer). Once its context is done, a running check
+// is cancelled and status changes are no longer reported.
+type ServerCheck struct {
+	ctx                 context.Context
+	name                string
+	config              ServerConfiguration
+	client              *http.Client
+	statusUpdateChannel chan<- StatusUpdate
+	schedule            *cronSchedule
+	activeHours         *activeHours
+	previousStatus      string
+	failures            int
+}
+
+func NewServerCheck(ctx context.Context, name string, config ServerConfiguration, statusUpdateChannel chan<- StatusUpdate) *ServerCheck {
+	client := &http.Client{Transport: checkTransport, Timeout: time.Duration(config.Timeout)}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return fmt.Errorf("Received a redirection as response")
+	}
+	log.Printf("Processing server %v with a timeout of %v\n", name, client.Timeout)
+	check := &ServerCheck{ctx: ctx, name: name, config: config, client: client, statusUpdateChannel: statusUpdateChannel}
+	// The configuration has already been validated at this point.
+	location, err := loadLocation(config.Timezone)
+	if err != nil {
+		log.Printf("Ignoring timezone of %s: %s\n", name, err.Error())
+		location = time.Local
+	}
+	if config.Schedule != "" {
+		if check.schedule, err = parseCronSchedule(config.Schedule, location); err != nil {
+			log.Printf("Ignoring schedule of %s: %s\n", name, err.Error())
+		}
+	}
+	if config.ActiveHours != nil {
+		if check.activeHours, err = parseActiveHours(config.ActiveHours, location); err != nil {
+			log.Printf("Ignoring active hours of %s: %s\n", name, err.Error())
+		}
+	}
+	return check
+}
+
+// Run checks the server once and returns the delay until the next check.
+// The delay is determined by the server's schedule if it has one. Otherwise
+// the backoff policy is used while the server is offline. Only the latter
+// and the server's delay may be jittered.
+func (c *ServerCheck) Run() (time.Duration, bool) {
+	if c.ctx.Err() != nil {
+		return 0, false
+	}
+	now := time.Now()
+	silent := false
+	if c.activeHours != nil && !c.activeHours.Contains(now) {
+		if c.config.ActiveHours.Mode != ACTIVE_HOURS_ALERT {
+			log.Printf("Skipping check of %s outside of its active hours\n", c.name)
+			return c.nextDelay(now, c.activeHours.NextStart(now))
+		}
+		silent = true
+	}
+
+	newStatus, reason, duration := c.check()
+	// Make sure that a failure isn't just a hiccup before reporting it.
+	for retry := 0; newStatus == STATUS_OFFLINE && c.ctx.Err() == nil && c.previousStatus != STATUS_OFFLINE && retry < c.config.Backoff.Retries; retry++ {
+		log.Printf("Rechecking %s (%d/%d)\n", c.name, retry+1, c.config.Backoff.Retries)
+		newStatus, reason, duration = c.check()
+	}
+	if newStatus == STATUS_ONLINE {
+		log.Printf("%s is online\n", c.name)
+		c.failures = 0
+	} else {
+		log.Printf("%s is offline\n", c.name)
+		c.failures++
+	}
+
+	// The server might have been removed while we were checking it.
+	if c.ctx.Err() != nil {
+		return 0, false
+	}
+	if newStatus != c.previousStatus {
+		c.statusUpdateChannel <- StatusUpdate{ServerName: c.name, Status: newStatus, Duration: duration, Reason: reason, Silent: silent}
+	}
+	c.previousStatus = newStatus
+
+	if c.schedule != nil {
+		now = time.Now()
+		return c.nextDelay(now, c.schedule.Next(now))
+	}
+	if newStatus == STATUS_OFFLINE {
+		return c.config.Backoff.NextDelay(c.failures), true
+	}
+	return time.Duration(c.config.Delay), true
+}
+
+// firstDelay returns the time until the first check. Servers with a
+// schedule are first checked at their next slot and servers outside of
+// their active hours once these start. Other servers may be checked at any
+// time within their delay, which is signalled by returning true.
+func (c *ServerCheck) firstDelay(now time.Time) (time.Duration, bool) {
+	if c.schedule != nil {
+		return c.nextDelay(now, c.schedule.Next(now))
+	}
+	if c.activeHours != nil && !c.activeHours.Contains(now) && c.config.ActiveHours.Mode != ACTIVE_HOURS_ALERT {
+		return c.nextDelay(now, c.activeHours.NextStart(now))
+	}
+	return time.Duration(c.config.Delay), true
+}
+
+// nextDelay returns the time until next or the server's delay if there is
+// no next time. Only the latter may be jittered.
+func (c *ServerCheck) nextDelay(now, next time.Time) (time.Duration, bool) {
+	if next.IsZero() {
+		return time.Duration(c.config.Delay), true
+	}
+	return next.Sub(now), false
+}
+
+// check sends a single request to the server and returns the resulting
+// status together with the reason for being offline.
+func (c *ServerCheck) check() (string, string, time.Duration) {
+	startTime := time.Now()
+	resp, err := checkServer(c.ctx, c.client, c.config)
+	duration := time.Now().Sub(startTime)
+	if err != nil {
+		log.Println(err)
+		return STATUS_OFFLINE, err.Error(), duration
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		log.Printf("Returned status %v", resp.StatusCode)
+		return STATUS_OFFLINE, fmt.Sprintf("Returned status %v", resp.StatusCode), duration
+	}
+	return STATUS_ONLINE, "", duration
+}
+
+// checkServer sends a single request to the server's isAliveUrl including all
+// configured headers and credentials.
+func checkServer(ctx context.Context, client *http.Client, serverConfig ServerConfiguration) (*http.Response, error) {
+	req, err := http.NewRequest("GET", serverConfig.IsAliveUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	for name, value := range serverConfig.Headers {
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+		} else {
+			req.Header.Set(name, value)
+		}
+	}
+	if serverConfig.BasicAuth != nil {
+		req.SetBasicAuth(serverConfig.BasicAuth.Username, serverConfig.BasicAuth.Password)
+	}
+	return client.Do(req)
+}
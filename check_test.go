@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBackoffNextDelay(t *testing.T) {
+	exponential := &BackoffConfiguration{Policy: BACKOFF_EXPONENTIAL, Delay: Duration(time.Second), MaxDelay: Duration(5 * time.Second)}
+	for failures, expected := range []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if delay := exponential.NextDelay(failures); delay != expected {
+			t.Errorf("Expected %v after %d failures, got %v", expected, failures, delay)
+		}
+	}
+	fixed := &BackoffConfiguration{Policy: BACKOFF_FIXED, Delay: Duration(5 * time.Second)}
+	if delay := fixed.NextDelay(10); delay != 5*time.Second {
+		t.Errorf("Expected a fixed delay of 5s, got %v", delay)
+	}
+}
+
+func TestServerCheckBackoff(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only the very first request fails.
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	updates := make(chan StatusUpdate, 10)
+	config := (*Configuration)(nil).ResolveServer(ServerConfiguration{
+		IsAliveUrl: server.URL,
+		Delay:      Duration(time.Minute),
+		Backoff:    &BackoffConfiguration{Delay: Duration(5 * time.Second), Retries: 1},
+	})
+	check := NewServerCheck(context.Background(), "flaky", config, updates)
+	if delay, jitter := check.Run(); delay != time.Minute || !jitter {
+		t.Errorf("Expected the retry to succeed, got a delay of %v", delay)
+	}
+	if update := <-updates; update.Status != STATUS_ONLINE || atomic.LoadInt32(&requests) != 2 {
+		t.Errorf("Unexpected update %v after %d requests", update, requests)
+	}
+
+	server.Close()
+	if delay, jitter := check.Run(); delay != 5*time.Second || !jitter {
+		t.Errorf("Expected the backoff delay while offline, got %v", delay)
+	}
+	if update := <-updates; update.Status != STATUS_OFFLINE {
+		t.Errorf("Unexpected update %v", update)
+	}
+}
+
+func TestServerCheckScheduleIsNotJittered(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	config := (*Configuration)(nil).ResolveServer(ServerConfiguration{IsAliveUrl: server.URL, Schedule: "*/5 * * * *"})
+	check := NewServerCheck(context.Background(), "scheduled", config, make(chan StatusUpdate, 1))
+	if delay, jitter := check.Run(); delay > 5*time.Minute || jitter {
+		t.Errorf("Expected the delay until the next slot without jitter, got %v (%v)", delay, jitter)
+	}
+}
+
+func TestServerCheckFirstDelay(t *testing.T) {
+	now := time.Date(2024, 3, 4, 21, 1, 0, 0, time.UTC)
+	cases := []struct {
+		config ServerConfiguration
+		delay  time.Duration
+		jitter bool
+	}{
+		{ServerConfiguration{Delay: Duration(time.Minute)}, time.Minute, true},
+		{ServerConfiguration{Schedule: "*/5 * * * *", Timezone: "UTC"}, 4 * time.Minute, false},
+		{ServerConfiguration{ActiveHours: &ActiveHoursConfiguration{From: "08:00", To: "20:00"}, Timezone: "UTC"}, 10*time.Hour + 59*time.Minute, false},
+		{ServerConfiguration{Delay: Duration(time.Minute), ActiveHours: &ActiveHoursConfiguration{From: "08:00", To: "20:00", Mode: ACTIVE_HOURS_ALERT}}, time.Minute, true},
+	}
+	for _, c := range cases {
+		c.config.IsAliveUrl = "http://localhost:1/"
+		check := NewServerCheck(context.Background(), "first", (*Configuration)(nil).ResolveServer(c.config), make(chan StatusUpdate, 1))
+		if delay, jitter := check.firstDelay(now); delay != c.delay || jitter != c.jitter {
+			t.Errorf("Expected a first delay of %v (%v) for %v, got %v (%v)", c.delay, c.jitter, c.config, delay, jitter)
+		}
+	}
+}
+
+func TestServerCheckCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hang until the client gives up.
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	updates := make(chan StatusUpdate, 1)
+	config := (*Configuration)(nil).ResolveServer(ServerConfiguration{IsAliveUrl: server.URL, Timeout: Duration(time.Minute), Delay: Duration(time.Minute)})
+	check := NewServerCheck(ctx, "hanging", config, updates)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	started := time.Now()
+	if delay, _ := check.Run(); delay != 0 {
+		t.Errorf("Expected no further checks, got a delay of %v", delay)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Expected the check to be cancelled, took %v", elapsed)
+	}
+	if len(updates) != 0 {
+		t.Errorf("Expected no status update for a cancelled check, got %v", <-updates)
+	}
+}
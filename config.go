@@ -0,0 +1,541 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	AGGREGATE_ALL = "all"
+	AGGREGATE_ANY = "any"
+)
+
+const (
+	BACKOFF_FIXED       = "fixed"
+	BACKOFF_EXPONENTIAL = "exponential"
+)
+
+const (
+	DEFAULT_BACKOFF_MAX_DELAY = 10 * time.Minute
+	DEFAULT_TIMEOUT           = 30 * time.Second
+	DEFAULT_DELAY             = 30 * time.Second
+	STATUS_OFFLINE            = "offline"
+	STATUS_ONLINE             = "online"
+)
+
+type ServerConfiguration struct {
+	IsAliveUrl string                  `yaml:"isAliveUrl" json:"isAliveUrl"`
+	Group      string                  `yaml:"group,omitempty" json:"group,omitempty"`
+	Tags       []string                `yaml:"tags,omitempty" json:"tags,omitempty"`
+	Timeout    Duration                `yaml:"timeout,omitempty" json:"timeout,omitempty"`
+	Delay      Duration                `yaml:"delay,omitempty" json:"delay,omitempty"`
+	Headers    map[string]string       `yaml:"headers,omitempty" json:"headers,omitempty"`
+	BasicAuth  *BasicAuthConfiguration `yaml:"basicAuth,omitempty" json:"basicAuth,omitempty"`
+	Backoff    *BackoffConfiguration   `yaml:"backoff,omitempty" json:"backoff,omitempty"`
+	// Schedule is a cron expression that is used instead of the delay. It
+	// is interpreted within Timezone just like ActiveHours.
+	Schedule    string                    `yaml:"schedule,omitempty" json:"schedule,omitempty"`
+	Timezone    string                    `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+	ActiveHours *ActiveHoursConfiguration `yaml:"activeHours,omitempty" json:"activeHours,omitempty"`
+	// Discover turns the server into a group whose members are looked up
+	// periodically (e.g. "srv:_http._tcp.example.com"). The isAliveUrl is
+	// then a template for every member.
+	Discover        string   `yaml:"discover,omitempty" json:"discover,omitempty"`
+	RefreshInterval Duration `yaml:"refreshInterval,omitempty" json:"refreshInterval,omitempty"`
+	Aggregate       string   `yaml:"aggregate,omitempty" json:"aggregate,omitempty"`
+}
+
+// BasicAuthConfiguration holds the credentials sent along with every check
+// of a server.
+type BasicAuthConfiguration struct {
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"password"`
+}
+
+// BackoffConfiguration controls how a server is checked while it is
+// offline. With the fixed policy it is rechecked every Delay, with the
+// exponential policy the delay is doubled after every failed check until
+// it reaches MaxDelay. Before a server is reported as offline, it is
+// rechecked up to Retries times immediately.
+type BackoffConfiguration struct {
+	Policy   string   `yaml:"policy,omitempty" json:"policy,omitempty"`
+	Delay    Duration `yaml:"delay,omitempty" json:"delay,omitempty"`
+	MaxDelay Duration `yaml:"maxDelay,omitempty" json:"maxDelay,omitempty"`
+	Retries  int      `yaml:"retries,omitempty" json:"retries,omitempty"`
+}
+
+// NextDelay returns the delay after the given number of consecutive
+// failed checks.
+func (b *BackoffConfiguration) NextDelay(failures int) time.Duration {
+	delay := time.Duration(b.Delay)
+	if b.Policy != BACKOFF_EXPONENTIAL {
+		return delay
+	}
+	for i := 1; i < failures && delay < time.Duration(b.MaxDelay); i++ {
+		delay *= 2
+	}
+	if delay > time.Duration(b.MaxDelay) {
+		delay = time.Duration(b.MaxDelay)
+	}
+	return delay
+}
+
+// ServerDefaults are used for every setting a server doesn't specify
+// itself. They can be defined globally and per group of servers.
+type ServerDefaults struct {
+	Timeout     Duration                  `yaml:"timeout"`
+	Delay       Duration                  `yaml:"delay"`
+	Backoff     *BackoffConfiguration     `yaml:"backoff"`
+	Schedule    string                    `yaml:"schedule"`
+	Timezone    string                    `yaml:"timezone"`
+	ActiveHours *ActiveHoursConfiguration `yaml:"activeHours"`
+}
+
+// ActiveHoursConfiguration limits checks (mode "check", the default) or
+// notifications (mode "alert") to the given days of the week (e.g.
+// "MON-FRI") and times of the day (e.g. from "08:00" to "20:00").
+type ActiveHoursConfiguration struct {
+	Days string `yaml:"days,omitempty" json:"days,omitempty"`
+	From string `yaml:"from,omitempty" json:"from,omitempty"`
+	To   string `yaml:"to,omitempty" json:"to,omitempty"`
+	Mode string `yaml:"mode,omitempty" json:"mode,omitempty"`
+}
+
+type HttpConfiguration struct {
+	HostAddr string `yaml:"addr"`
+	// PublicUrl is the address under which the dashboard can be reached
+	// by the recipients of notifications.
+	PublicUrl string `yaml:"publicUrl"`
+}
+
+type ApiConfiguration struct {
+	Token     string `yaml:"token"`
+	StateFile string `yaml:"stateFile"`
+}
+
+type SlackConfiguration struct {
+	Token            string              `yaml:"token"`
+	Team             string              `yaml:"team"`
+	NotifiedChannels map[string][]string `yaml:"channels"`
+}
+
+// NotifierConfiguration describes a single named notifier. Type selects the
+// backend whose settings are configured in the block of the same name.
+// Status changes are only sent to the notifier if the server is part of
+// Servers (if given) and has one of the Tags (if given).
+type NotifierConfiguration struct {
+	Name         string                             `yaml:"name"`
+	Type         string                             `yaml:"type"`
+	Servers      []string                           `yaml:"servers"`
+	Tags         []string                           `yaml:"tags"`
+	Slack        *SlackNotifierConfiguration        `yaml:"slack"`
+	Webhook      *WebhookNotifierConfiguration      `yaml:"webhook"`
+	Email        *EmailNotifierConfiguration        `yaml:"email"`
+	PagerDuty    *PagerDutyNotifierConfiguration    `yaml:"pagerduty"`
+	Alertmanager *AlertmanagerNotifierConfiguration `yaml:"alertmanager"`
+	Teams        *TeamsNotifierConfiguration        `yaml:"teams"`
+	Discord      *DiscordNotifierConfiguration      `yaml:"discord"`
+	Mattermost   *MattermostNotifierConfiguration   `yaml:"mattermost"`
+	Telegram     *TelegramNotifierConfiguration     `yaml:"telegram"`
+	Matrix       *MatrixNotifierConfiguration       `yaml:"matrix"`
+	Exec         *ExecNotifierConfiguration         `yaml:"exec"`
+}
+
+// SlackNotifierConfiguration is used by notifiers of the type "slack".
+// Messages are either sent through an incoming webhook (WebhookUrl) or the
+// Web API (BotToken). Team and Token configure the deprecated webhook
+// integration.
+type SlackNotifierConfiguration struct {
+	WebhookUrl  string   `yaml:"webhookUrl"`
+	BotToken    string   `yaml:"botToken"`
+	Token       string   `yaml:"token"`
+	Team        string   `yaml:"team"`
+	Channels    []string `yaml:"channels"`
+	Username    string   `yaml:"username"`
+	OnlineIcon  string   `yaml:"onlineIcon"`
+	OfflineIcon string   `yaml:"offlineIcon"`
+}
+
+// WebhookNotifierConfiguration is used by notifiers of the type "webhook".
+// Template is a text/template for the request body, which is a JSON
+// document describing the status change by default.
+type WebhookNotifierConfiguration struct {
+	Url             string            `yaml:"url"`
+	Headers         map[string]string `yaml:"headers"`
+	Template        string            `yaml:"template"`
+	ContentType     string            `yaml:"contentType"`
+	Secret          string            `yaml:"secret"`
+	SignatureHeader string            `yaml:"signatureHeader"`
+}
+
+// EmailNotifierConfiguration is used by notifiers of the type "email".
+// Every mail is sent to To and the Recipients of the servers it covers.
+// StartTLS is either "auto" (the default, used if the server supports it),
+// "required" or "disabled". Auth is either "plain" (the default) or "login"
+// and only used if a Username is given. Subject and TextTemplate are
+// text/templates and HtmlTemplate is a html/template that is sent as an
+// alternative to the text. Status changes happening within BatchWindow are
+// sent in a single mail.
+type EmailNotifierConfiguration struct {
+	Host               string              `yaml:"host"`
+	Port               int                 `yaml:"port"`
+	StartTLS           string              `yaml:"startTLS"`
+	InsecureSkipVerify bool                `yaml:"insecureSkipVerify"`
+	Auth               string              `yaml:"auth"`
+	Username           string              `yaml:"username"`
+	Password           string              `yaml:"password"`
+	From               string              `yaml:"from"`
+	To                 []string            `yaml:"to"`
+	Recipients         map[string][]string `yaml:"recipients"`
+	Subject            string              `yaml:"subject"`
+	TextTemplate       string              `yaml:"textTemplate"`
+	HtmlTemplate       string              `yaml:"htmlTemplate"`
+	BatchWindow        Duration            `yaml:"batchWindow"`
+}
+
+// PagerDutyNotifierConfiguration is used by notifiers of the type
+// "pagerduty". RoutingKeys and Severities override RoutingKey and Severity
+// per server. Severities can also be given per tag.
+type PagerDutyNotifierConfiguration struct {
+	RoutingKey  string            `yaml:"routingKey"`
+	RoutingKeys map[string]string `yaml:"routingKeys"`
+	Severity    string            `yaml:"severity"`
+	Severities  map[string]string `yaml:"severities"`
+}
+
+// AlertmanagerNotifierConfiguration is used by notifiers of the type
+// "alertmanager". Url is the base URL of Alertmanager. Labels are added to
+// every alert. Active alerts are re-sent every ResendInterval, which has to
+// be shorter than Alertmanager's resolve_timeout.
+type AlertmanagerNotifierConfiguration struct {
+	Url            string            `yaml:"url"`
+	Headers        map[string]string `yaml:"headers"`
+	AlertName      string            `yaml:"alertName"`
+	Labels         map[string]string `yaml:"labels"`
+	ResendInterval Duration          `yaml:"resendInterval"`
+}
+
+// TeamsNotifierConfiguration is used by notifiers of the type "teams".
+type TeamsNotifierConfiguration struct {
+	WebhookUrl string `yaml:"webhookUrl"`
+}
+
+// DiscordNotifierConfiguration is used by notifiers of the type "discord".
+type DiscordNotifierConfiguration struct {
+	WebhookUrl string `yaml:"webhookUrl"`
+	Username   string `yaml:"username"`
+	AvatarUrl  string `yaml:"avatarUrl"`
+}
+
+// MattermostNotifierConfiguration is used by notifiers of the type
+// "mattermost". Without Channels, the webhook's default channel is used.
+type MattermostNotifierConfiguration struct {
+	WebhookUrl string   `yaml:"webhookUrl"`
+	Channels   []string `yaml:"channels"`
+	Username   string   `yaml:"username"`
+	IconUrl    string   `yaml:"iconUrl"`
+}
+
+// TelegramNotifierConfiguration is used by notifiers of the type
+// "telegram". ChatId is either the numeric ID of a chat or the @username of
+// a channel.
+type TelegramNotifierConfiguration struct {
+	BotToken string `yaml:"botToken"`
+	ChatId   string `yaml:"chatId"`
+}
+
+// MatrixNotifierConfiguration is used by notifiers of the type "matrix".
+type MatrixNotifierConfiguration struct {
+	HomeserverUrl string `yaml:"homeserverUrl"`
+	RoomId        string `yaml:"roomId"`
+	AccessToken   string `yaml:"accessToken"`
+}
+
+// ExecNotifierConfiguration is used by notifiers of the type "exec". The
+// Command is run with the given Args in Dir for every status change and
+// killed after Timeout (at most and by default NOTIFICATION_TIMEOUT). Env
+// adds environment variables to statusd's own.
+type ExecNotifierConfiguration struct {
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Dir     string            `yaml:"dir"`
+	Env     map[string]string `yaml:"env"`
+	Timeout Duration          `yaml:"timeout"`
+}
+
+type DiscoveryConfiguration struct {
+	Files  []FileDiscoveryConfiguration  `yaml:"files"`
+	Docker *DockerDiscoveryConfiguration `yaml:"docker"`
+}
+
+type DockerDiscoveryConfiguration struct {
+	Socket          string   `yaml:"socket"`
+	RefreshInterval Duration `yaml:"refreshInterval"`
+}
+
+// FileDiscoveryConfiguration describes a set of target files and how each
+// target is checked. Name as well as the isAliveUrl, group, headers and
+// basicAuth of Check are templates that have access to the target (.Target)
+// and its labels (.Labels).
+type FileDiscoveryConfiguration struct {
+	Files           []string            `yaml:"files"`
+	RefreshInterval Duration            `yaml:"refreshInterval"`
+	Name            string              `yaml:"name"`
+	Check           ServerConfiguration `yaml:"check"`
+}
+
+// SchedulerConfiguration controls how many checks may run concurrently and
+// by how much (as a fraction of a server's delay) checks are randomly
+// delayed in order to spread them over time.
+type SchedulerConfiguration struct {
+	MaxConcurrency int     `yaml:"maxConcurrency"`
+	Jitter         float64 `yaml:"jitter"`
+}
+
+type Configuration struct {
+	Include   []string                       `yaml:"include"`
+	Defaults  ServerDefaults                 `yaml:"defaults"`
+	Groups    map[string]ServerDefaults      `yaml:"groups"`
+	Servers   map[string]ServerConfiguration `yaml:"servers"`
+	Slack     SlackConfiguration             `yaml:"slack"`
+	Notifiers []NotifierConfiguration        `yaml:"notifiers"`
+	Http      HttpConfiguration              `yaml:"http"`
+	Api       ApiConfiguration               `yaml:"api"`
+	Discovery DiscoveryConfiguration         `yaml:"discovery"`
+	Scheduler SchedulerConfiguration         `yaml:"scheduler"`
+}
+
+// A ConfigurationFragment is a file included by the main configuration. It
+// can only define servers and how they are notified.
+type ConfigurationFragment struct {
+	Servers   map[string]ServerConfiguration `yaml:"servers"`
+	Notifiers []NotifierConfiguration        `yaml:"notifiers"`
+	Slack     struct {
+		NotifiedChannels map[string][]string `yaml:"channels"`
+	} `yaml:"slack"`
+}
+
+// NewConfiguration parses YAML data provided through a Reader
+// into our configuration object. If any error occurs, no
+// Configuration will be returned and an error is generated.
+// Unknown keys and invalid values are reported as
+// ConfigurationErrors.
+//
+// Before the data is parsed, "!file /path" references are replaced with
+// the content of the referenced file. Afterwards ${VAR} references to
+// environment variables are resolved within all values.
+//
+// Included and referenced files are looked up relative to the working
+// directory.
+func NewConfiguration(r io.Reader) (*Configuration, error) {
+	rawData, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return newConfiguration(rawData, "", ".")
+}
+
+// NewConfigurationFromFile creates a new Configuration struct from
+// the file behind the given path. Included and referenced files are
+// looked up relative to the directory of the file they are used in.
+func NewConfigurationFromFile(path string) (*Configuration, error) {
+	rawData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newConfiguration(rawData, path, filepath.Dir(path))
+}
+
+func newConfiguration(rawData []byte, filename string, baseDir string) (*Configuration, error) {
+	result := &Configuration{}
+	v, err := loadConfigurationData(rawData, filename, baseDir, result)
+	if err != nil {
+		return nil, err
+	}
+	v.validateConfiguration(result)
+	errs := v.errors
+	for idx, _ := range result.Discovery.Files {
+		result.Discovery.Files[idx].Files = resolvePaths(result.Discovery.Files[idx].Files, baseDir)
+	}
+	// Remember which file defined a server, notifier or channels in order
+	// to report duplicates and invalid references in the right place.
+	serverOrigins := make(map[string]*configurationValidator)
+	notifierOrigins := make(map[string]*configurationValidator)
+	channelOrigins := make(map[string]*configurationValidator)
+	for name, _ := range result.Servers {
+		serverOrigins[name] = v
+	}
+	for _, notifier := range result.Notifiers {
+		notifierOrigins[notifier.Name] = v
+	}
+	for name, _ := range result.Slack.NotifiedChannels {
+		channelOrigins[name] = v
+	}
+	includes, err := expandIncludes(result.Include, baseDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range includes {
+		includedData, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		fragment := &ConfigurationFragment{}
+		fv, err := loadConfigurationData(includedData, path, filepath.Dir(path), fragment)
+		if err != nil {
+			return nil, err
+		}
+		fv.validateServers(result, fragment.Servers)
+		if result.Servers == nil {
+			result.Servers = make(map[string]ServerConfiguration)
+		}
+		for name, serverConfig := range fragment.Servers {
+			if origin, found := serverOrigins[name]; found {
+				fv.addError("servers."+name, "server %s is already defined in %s", name, origin.describe())
+				continue
+			}
+			serverOrigins[name] = fv
+			result.Servers[name] = serverConfig
+		}
+		fv.validateNotifiers(fragment.Notifiers)
+		for idx, notifier := range fragment.Notifiers {
+			if origin, found := notifierOrigins[notifier.Name]; found {
+				// Duplicates within the same file are reported by
+				// validateNotifiers.
+				if origin != fv {
+					fv.addError(fmt.Sprintf("notifiers.%d", idx), "notifier %s is already defined in %s", notifier.Name, origin.describe())
+				}
+				continue
+			}
+			notifierOrigins[notifier.Name] = fv
+			result.Notifiers = append(result.Notifiers, notifier)
+		}
+		if result.Slack.NotifiedChannels == nil {
+			result.Slack.NotifiedChannels = make(map[string][]string)
+		}
+		for name, channels := range fragment.Slack.NotifiedChannels {
+			if origin, found := channelOrigins[name]; found {
+				fv.addError("slack.channels."+name, "slack channels for server %s are already defined in %s", name, origin.describe())
+				continue
+			}
+			channelOrigins[name] = fv
+			result.Slack.NotifiedChannels[name] = channels
+		}
+		errs = append(errs, fv.errors...)
+	}
+	for name, origin := range channelOrigins {
+		if _, found := result.Servers[name]; !found {
+			errs = append(errs, origin.newError("slack.channels."+name, "slack channels reference undefined server %s", name))
+		}
+	}
+	if len(errs) != 0 {
+		sort.Sort(errs)
+		return nil, errs
+	}
+	return result, nil
+}
+
+// expandIncludes returns all files matching the given glob patterns in a
+// stable order. Relative patterns are resolved against baseDir.
+func expandIncludes(patterns []string, baseDir string) ([]string, error) {
+	var result []string
+	seen := make(map[string]bool)
+	for _, pattern := range resolvePaths(patterns, baseDir) {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid include pattern %s: %s", pattern, err.Error())
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				result = append(result, match)
+			}
+		}
+	}
+	return result, nil
+}
+
+// resolvePaths makes all relative paths absolute by joining them with
+// baseDir.
+func resolvePaths(paths []string, baseDir string) []string {
+	result := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(baseDir, path)
+		}
+		result = append(result, path)
+	}
+	return result
+}
+
+// ResolveServer returns the given server configuration with every
+// unspecified setting taken from the server's group, the global defaults
+// or the built-in defaults (in that order). If the timeout isn't
+// configured anywhere, it is capped at the delay. Without a backoff
+// policy, offline servers are rechecked every two times the delay.
+func (c *Configuration) ResolveServer(serverConfig ServerConfiguration) ServerConfiguration {
+	var layers []ServerDefaults
+	if c != nil {
+		if group, found := c.Groups[serverConfig.Group]; found {
+			layers = append(layers, group)
+		}
+		layers = append(layers, c.Defaults)
+	}
+	for _, defaults := range layers {
+		// A delay configured on a more specific level wins over a schedule
+		// and vice versa.
+		if serverConfig.Schedule == "" && serverConfig.Delay == 0 {
+			serverConfig.Schedule = defaults.Schedule
+		}
+		if serverConfig.Timezone == "" {
+			serverConfig.Timezone = defaults.Timezone
+		}
+		if serverConfig.ActiveHours == nil {
+			serverConfig.ActiveHours = defaults.ActiveHours
+		}
+		if serverConfig.Timeout == 0 {
+			serverConfig.Timeout = defaults.Timeout
+		}
+		if serverConfig.Delay == 0 {
+			serverConfig.Delay = defaults.Delay
+		}
+		if serverConfig.Backoff == nil {
+			serverConfig.Backoff = defaults.Backoff
+		}
+	}
+	if serverConfig.Delay == 0 {
+		serverConfig.Delay = Duration(DEFAULT_DELAY)
+	}
+	if serverConfig.Timeout == 0 {
+		serverConfig.Timeout = Duration(DEFAULT_TIMEOUT)
+		if serverConfig.Timeout > serverConfig.Delay {
+			serverConfig.Timeout = serverConfig.Delay
+		}
+	}
+	backoff := BackoffConfiguration{}
+	if serverConfig.Backoff != nil {
+		backoff = *serverConfig.Backoff
+	}
+	if backoff.Policy == "" {
+		backoff.Policy = BACKOFF_FIXED
+	}
+	if backoff.Delay == 0 {
+		backoff.Delay = serverConfig.Delay
+		if backoff.Policy == BACKOFF_FIXED {
+			backoff.Delay *= 2
+		}
+	}
+	if backoff.Policy == BACKOFF_EXPONENTIAL && backoff.MaxDelay == 0 {
+		backoff.MaxDelay = Duration(DEFAULT_BACKOFF_MAX_DELAY)
+		if backoff.MaxDelay < backoff.Delay {
+			backoff.MaxDelay = backoff.Delay
+		}
+	}
+	serverConfig.Backoff = &backoff
+	return serverConfig
+}
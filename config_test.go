@@ -0,0 +1,259 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewConfigurationReportsProblemsWithLines(t *testing.T) {
+	data := `servers:
+    server1:
+        isAliveURL: http://example.com
+        timout: 10
+    server2:
+        isAliveUrl: http://example.com
+        timeout: 90
+        delay: 60
+    server3:
+        isAliveUrl: example.com
+        delay: -1
+slack:
+    channels:
+        server4:
+            - "#project"
+`
+	_, err := NewConfiguration(strings.NewReader(data))
+	errs, ok := err.(ConfigurationErrors)
+	if !ok {
+		t.Fatalf("Expected ConfigurationErrors, got %v", err)
+	}
+	expected := []string{
+		`line 2: server server1: isAliveUrl is missing`,
+		`line 3: unknown field "isAliveURL" in servers.server1`,
+		`line 4: unknown field "timout" in servers.server1`,
+		`line 5: server server2: timeout (1m30s) is larger than delay (1m0s)`,
+		`line 9: server server3: delay must not be negative`,
+		`line 9: server server3: isAliveUrl "example.com" is not an absolute HTTP(S) URL`,
+		`line 14: slack channels reference undefined server server4`,
+	}
+	if len(errs) != len(expected) {
+		t.Fatalf("Expected %d errors, got %d:\n%s", len(expected), len(errs), errs.Error())
+	}
+	for idx, e := range errs {
+		if e.Error() != expected[idx] {
+			t.Errorf("Expected %q, got %q", expected[idx], e.Error())
+		}
+	}
+}
+
+func TestYamlKeyLinesWithSequences(t *testing.T) {
+	data := `top:
+  - name: first
+    value: |
+      nested: ignored
+  - name: second
+other: 1
+`
+	lines := yamlKeyLines([]byte(data))
+	expected := map[string]int{
+		"top":         1,
+		"top.0":       2,
+		"top.0.name":  2,
+		"top.0.value": 3,
+		"top.1.name":  5,
+		"other":       6,
+	}
+	for path, line := range expected {
+		if lines[path] != line {
+			t.Errorf("Expected %s on line %d, got %d", path, line, lines[path])
+		}
+	}
+	if _, found := lines["top.0.value.nested"]; found {
+		t.Error("Keys within block scalars must be ignored")
+	}
+}
+
+func TestResolveServerDefaults(t *testing.T) {
+	data := `defaults:
+    timeout: 5s
+    delay: 2m
+groups:
+    latency:
+        timeout: 500ms
+        delay: 10s
+servers:
+    plain:
+        isAliveUrl: http://example.com
+    legacy:
+        isAliveUrl: http://example.com
+        timeout: 10
+        delay: 60
+    fast:
+        isAliveUrl: http://example.com
+        group: latency
+        delay: 1s
+`
+	config, err := NewConfiguration(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]ServerDefaults{
+		"plain":  {Timeout: Duration(5 * time.Second), Delay: Duration(2 * time.Minute)},
+		"legacy": {Timeout: Duration(10 * time.Second), Delay: Duration(time.Minute)},
+		"fast":   {Timeout: Duration(500 * time.Millisecond), Delay: Duration(time.Second)},
+	}
+	for name, defaults := range expected {
+		resolved := config.ResolveServer(config.Servers[name])
+		if resolved.Timeout != defaults.Timeout || resolved.Delay != defaults.Delay {
+			t.Errorf("%s: expected timeout %v and delay %v, got %v and %v", name, defaults.Timeout, defaults.Delay, resolved.Timeout, resolved.Delay)
+		}
+	}
+
+	resolved := (&Configuration{}).ResolveServer(ServerConfiguration{Delay: Duration(10 * time.Second)})
+	if resolved.Timeout != Duration(10*time.Second) {
+		t.Errorf("The built-in default timeout should be capped at the delay, got %v", resolved.Timeout)
+	}
+}
+
+func TestNewConfigurationInterpolation(t *testing.T) {
+	secretFile := filepath.Join(t.TempDir(), "slack_token")
+	if err := ioutil.WriteFile(secretFile, []byte("s3cr$t\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("STATUSD_TEST_HOST", "example.com")
+	defer os.Unsetenv("STATUSD_TEST_HOST")
+	data := `servers:
+    server1:
+        isAliveUrl: http://${STATUSD_TEST_HOST}/health
+        headers:
+            X-Api-Key: ${STATUSD_TEST_MISSING:-fallback}
+        basicAuth:
+            username: monitor
+            password: !file ` + secretFile + `
+slack:
+    token: !file ` + secretFile + `
+`
+	config, err := NewConfiguration(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := config.Servers["server1"]
+	if server.IsAliveUrl != "http://example.com/health" {
+		t.Errorf("Unexpected isAliveUrl %s", server.IsAliveUrl)
+	}
+	if server.Headers["X-Api-Key"] != "fallback" {
+		t.Errorf("Unexpected header value %s", server.Headers["X-Api-Key"])
+	}
+	if server.BasicAuth.Password != "s3cr$t" || config.Slack.Token != "s3cr$t" {
+		t.Errorf("Unexpected secrets %s and %s", server.BasicAuth.Password, config.Slack.Token)
+	}
+
+	_, err = NewConfiguration(strings.NewReader("slack:\n    token: ${STATUSD_TEST_MISSING}\n"))
+	if err == nil || err.Error() != "Invalid configuration:\nline 2: environment variable STATUSD_TEST_MISSING referenced by slack.token is not set" {
+		t.Errorf("Unexpected error for a missing variable: %v", err)
+	}
+}
+
+func TestNewConfigurationFromFileReferences(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "conf.d"), 0755)
+	files := map[string]string{
+		"password":         "main-secret\n",
+		"conf.d/password":  "team-secret\n",
+		"statusd.yaml":     "include:\n    - conf.d/*.yaml\nservers:\n    main:\n        isAliveUrl: http://example.com\n        basicAuth:\n            username: monitor\n            password: !file password # relative to this file\n        headers:\n            X-Literal: \"!file password\"\n            X-Inline: see !file password\n",
+		"conf.d/team.yaml": "servers:\n    team:\n        isAliveUrl: http://team.example.com\n        basicAuth:\n            username: monitor\n            password: !file 'password'\nnotifiers:\n    - name: hook\n      type: webhook\n      webhook:\n          url: http://hook.example.com/\n          template: |\n              !file missing\n\n              {{.Text}}\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config, err := NewConfigurationFromFile(filepath.Join(dir, "statusd.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	main := config.Servers["main"]
+	if main.BasicAuth.Password != "main-secret" || config.Servers["team"].BasicAuth.Password != "team-secret" {
+		t.Errorf("Expected references relative to the referencing file, got %s and %s", main.BasicAuth.Password, config.Servers["team"].BasicAuth.Password)
+	}
+	if main.Headers["X-Literal"] != "!file password" || main.Headers["X-Inline"] != "see !file password" {
+		t.Errorf("Expected references within strings to be kept, got %v", main.Headers)
+	}
+	if template := config.Notifiers[0].Webhook.Template; template != "!file missing\n\n{{.Text}}\n" {
+		t.Errorf("Expected references within block scalars to be kept, got %q", template)
+	}
+}
+
+func TestNewConfigurationFromFileWithIncludes(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "conf.d"), 0755)
+	files := map[string]string{
+		"statusd.yaml":  "include:\n    - conf.d/*.yaml\nservers:\n    main:\n        isAliveUrl: http://example.com\n",
+		"conf.d/a.yaml": "servers:\n    team-a:\n        isAliveUrl: http://a.example.com\nslack:\n    channels:\n        team-a:\n            - \"#team-a\"\n",
+		"conf.d/b.yaml": "servers:\n    team-b:\n        isAliveUrl: http://b.example.com\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config, err := NewConfigurationFromFile(filepath.Join(dir, "statusd.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Servers) != 3 || config.Servers["team-b"].IsAliveUrl != "http://b.example.com" {
+		t.Errorf("Unexpected servers %v", config.Servers)
+	}
+	if channels := config.Slack.NotifiedChannels["team-a"]; len(channels) != 1 || channels[0] != "#team-a" {
+		t.Errorf("Unexpected channels %v", config.Slack.NotifiedChannels)
+	}
+
+	duplicate := filepath.Join(dir, "conf.d", "c.yaml")
+	ioutil.WriteFile(duplicate, []byte("servers:\n    main:\n        isAliveUrl: http://example.com\n"), 0644)
+	_, err = NewConfigurationFromFile(filepath.Join(dir, "statusd.yaml"))
+	errs, ok := err.(ConfigurationErrors)
+	if !ok || len(errs) != 1 {
+		t.Fatalf("Expected a single error, got %v", err)
+	}
+	if expected := duplicate + ":line 2: server main is already defined in " + filepath.Join(dir, "statusd.yaml"); errs[0].Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, errs[0].Error())
+	}
+}
+
+func TestIncludedNotifiers(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"statusd.yaml": "include:\n    - team-*.yaml\nnotifiers:\n    - name: ops\n      type: webhook\n      webhook:\n          url: https://ops.example.com/\n",
+		"team-a.yaml":  "servers:\n    team-a:\n        isAliveUrl: http://a.example.com\nnotifiers:\n    - name: team-a\n      type: webhook\n      servers: [team-a]\n      webhook:\n          url: https://a.example.com/hook\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config, err := NewConfigurationFromFile(filepath.Join(dir, "statusd.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Notifiers) != 2 || config.Notifiers[1].Name != "team-a" || config.Notifiers[1].Servers[0] != "team-a" {
+		t.Errorf("Unexpected notifiers %v", config.Notifiers)
+	}
+
+	duplicate := filepath.Join(dir, "team-b.yaml")
+	ioutil.WriteFile(duplicate, []byte("notifiers:\n    - name: ops\n      type: webhook\n      webhook:\n          url: https://b.example.com/\n    - name: broken\n      type: webhook\n"), 0644)
+	_, err = NewConfigurationFromFile(filepath.Join(dir, "statusd.yaml"))
+	errs, ok := err.(ConfigurationErrors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("Expected two errors, got %v", err)
+	}
+	if expected := duplicate + ":line 2: notifier ops is already defined in " + filepath.Join(dir, "statusd.yaml"); errs[0].Error() != expected {
+		t.Errorf("Expected %q, got %q", expected, errs[0].Error())
+	}
+	if !strings.Contains(errs[1].Error(), "notifier broken: missing webhook settings") {
+		t.Errorf("Expected the included notifier to be validated, got %q", errs[1].Error())
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ACTIVE_HOURS_CHECK = "check"
+	ACTIVE_HOURS_ALERT = "alert"
+)
+
+var cronMonthNames = map[string]int{
+	"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+	"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+}
+
+var cronDayNames = map[string]int{
+	"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+}
+
+// A cronSchedule is a parsed cron expression with the five standard fields
+// (minute, hour, day of month, month and day of week). Every field is a
+// bitmask of the values it matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// Like in cron, a day matches if either the day of month or the day of
+	// week matches as long as both are restricted.
+	domRestricted, dowRestricted bool
+	location                     *time.Location
+}
+
+// parseCronSchedule parses a cron expression like "*/5 8-20 * * MON-FRI".
+// Its times are interpreted within the given location.
+func parseCronSchedule(spec string, location *time.Location) (*cronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q must have 5 fields", spec)
+	}
+	schedule := &cronSchedule{location: location}
+	var err error
+	if schedule.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, err
+	}
+	if schedule.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, err
+	}
+	if schedule.dom, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, err
+	}
+	if schedule.month, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return nil, err
+	}
+	if schedule.dow, err = parseCronField(fields[4], 0, 7, cronDayNames); err != nil {
+		return nil, err
+	}
+	// Sunday can be written as both 0 and 7.
+	if schedule.dow&(1<<7) != 0 {
+		schedule.dow |= 1
+	}
+	schedule.domRestricted = fields[2] != "*"
+	schedule.dowRestricted = fields[4] != "*"
+	return schedule, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges (1-5),
+// steps (*/5 or 8-20/2) and names.
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if idx := strings.Index(part, "/"); idx != -1 {
+			var err error
+			if step, err = strconv.Atoi(part[idx+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:idx]
+		}
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = parseCronValue(bounds[0], min, max, names); err != nil {
+				return 0, err
+			}
+			end = start
+			if len(bounds) == 2 {
+				if end, err = parseCronValue(bounds[1], min, max, names); err != nil {
+					return 0, err
+				}
+			} else if step != 1 {
+				end = max
+			}
+			if end < start {
+				return 0, fmt.Errorf("invalid range %q", part)
+			}
+		}
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(value string, min, max int, names map[string]int) (int, error) {
+	if number, found := names[strings.ToUpper(value)]; found {
+		return number, nil
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min || number > max {
+		return 0, fmt.Errorf("invalid value %q (expected %d-%d)", value, min, max)
+	}
+	return number, nil
+}
+
+func (s *cronSchedule) matchesDay(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domRestricted && s.dowRestricted {
+		return domMatch || dowMatch
+	}
+	return domMatch && dowMatch
+}
+
+// Next returns the first time after t that matches the schedule or the
+// zero time if there is no such time within the next five years.
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.location)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, s.location)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if !s.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.location)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.location)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// activeHours describes the time window in which a server is checked or
+// notifications are sent.
+type activeHours struct {
+	days     uint64
+	from, to int // minutes since midnight
+	location *time.Location
+}
+
+// parseActiveHours parses the given configuration. Times are interpreted
+// within the given location.
+func parseActiveHours(config *ActiveHoursConfiguration, location *time.Location) (*activeHours, error) {
+	hours := &activeHours{location: location}
+	days := config.Days
+	if days == "" {
+		days = "*"
+	}
+	var err error
+	if hours.days, err = parseCronField(days, 0, 7, cronDayNames); err != nil {
+		return nil, err
+	}
+	if hours.days&(1<<7) != 0 {
+		hours.days |= 1
+	}
+	if hours.from, err = parseTimeOfDay(config.From, 0); err != nil {
+		return nil, err
+	}
+	if hours.to, err = parseTimeOfDay(config.To, 24*60); err != nil {
+		return nil, err
+	}
+	if hours.from == hours.to {
+		return nil, fmt.Errorf("active hours from %s to %s are empty", config.From, config.To)
+	}
+	return hours, nil
+}
+
+// parseTimeOfDay parses a time like "08:30" into the minutes since
+// midnight.
+func parseTimeOfDay(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q (expected HH:MM)", value)
+	}
+	return parsed.Hour()*60 + parsed.Minute(), nil
+}
+
+// Contains reports whether t is within the active hours. If the window
+// ends before it starts (e.g. from 22:00 to 06:00), it spans midnight.
+func (h *activeHours) Contains(t time.Time) bool {
+	t = t.In(h.location)
+	if h.days&(1<<uint(t.Weekday())) == 0 {
+		return false
+	}
+	minute := t.Hour()*60 + t.Minute()
+	if h.from < h.to {
+		return minute >= h.from && minute < h.to
+	}
+	return minute >= h.from || minute < h.to
+}
+
+// NextStart returns the next time after t that is within the active
+// hours.
+func (h *activeHours) NextStart(t time.Time) time.Time {
+	t = t.In(h.location)
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, h.location)
+	for i := 0; i < 8*24*60; i++ {
+		if h.Contains(t) {
+			return t
+		}
+		t = t.Add(time.Minute)
+	}
+	return time.Time{}
+}
+
+// loadLocation returns the location with the given name or the local time
+// zone if no name is given.
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(name)
+}
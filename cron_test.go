@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	location, _ := time.LoadLocation("UTC")
+	schedule, err := parseCronSchedule("*/5 8-20 * * MON-FRI", location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		now, expected string
+	}{
+		// Friday evening continues on Monday morning.
+		{"2024-03-01T20:55:00Z", "2024-03-04T08:00:00Z"},
+		{"2024-03-04T08:00:00Z", "2024-03-04T08:05:00Z"},
+		{"2024-03-04T08:03:59Z", "2024-03-04T08:05:00Z"},
+		{"2024-03-04T03:00:00Z", "2024-03-04T08:00:00Z"},
+	}
+	for _, test := range tests {
+		now, _ := time.Parse(time.RFC3339, test.now)
+		if next := schedule.Next(now).Format(time.RFC3339); next != test.expected {
+			t.Errorf("Expected %s after %s, got %s", test.expected, test.now, next)
+		}
+	}
+}
+
+func TestParseCronScheduleErrors(t *testing.T) {
+	for _, spec := range []string{"* * * *", "60 * * * *", "* * * * FOO", "5-1 * * * *", "*/0 * * * *"} {
+		if _, err := parseCronSchedule(spec, time.UTC); err == nil {
+			t.Errorf("Expected %q to be invalid", spec)
+		}
+	}
+}
+
+func TestActiveHours(t *testing.T) {
+	location, err := time.LoadLocation("Europe/Vienna")
+	if err != nil {
+		t.Skip("Timezone data not available")
+	}
+	hours, err := parseActiveHours(&ActiveHoursConfiguration{Days: "MON-FRI", From: "08:00", To: "20:00"}, location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"2024-03-04T07:30:00Z": true,  // 08:30 in Vienna
+		"2024-03-04T19:30:00Z": false, // 20:30 in Vienna
+		"2024-03-02T12:00:00Z": false, // Saturday
+	}
+	for value, expected := range tests {
+		now, _ := time.Parse(time.RFC3339, value)
+		if hours.Contains(now) != expected {
+			t.Errorf("Expected Contains(%s) to be %v", value, expected)
+		}
+	}
+	now, _ := time.Parse(time.RFC3339, "2024-03-01T19:30:00Z")
+	if next := hours.NextStart(now).UTC().Format(time.RFC3339); next != "2024-03-04T07:00:00Z" {
+		t.Errorf("Expected the active hours to start on Monday, got %s", next)
+	}
+
+	overnight, _ := parseActiveHours(&ActiveHoursConfiguration{From: "22:00", To: "06:00"}, time.UTC)
+	if midnight, _ := time.Parse(time.RFC3339, "2024-03-04T00:30:00Z"); !overnight.Contains(midnight) {
+		t.Error("Expected overnight active hours to span midnight")
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/zerok/statusd/Godeps/_workspace/src/gopkg.in/v2/yaml"
+)
+
+const (
+	DEFAULT_DISCOVERY_REFRESH_INTERVAL = 30 * time.Second
+	DEFAULT_DISCOVERY_NAME             = "{{.Target}}"
+)
+
+// A Discoverer determines a set of servers that should be checked, e.g. by
+// looking at files generated by some inventory system.
+type Discoverer interface {
+	Discover() (map[string]ServerConfiguration, error)
+}
+
+// A detailedDiscoverer also provides additional information about the
+// servers it has discovered, which is made available through the registry.
+type detailedDiscoverer interface {
+	Details() map[string]map[string]string
+}
+
+// DiscoveryHandler periodically asks the given Discoverer for servers and
+// synchronizes the pool with the result. If discovery fails, the previously
+// discovered servers are kept. The handler exits once the context is done.
+func DiscoveryHandler(ctx context.Context, source string, discoverer Discoverer, interval time.Duration, pool *ServerPool, doneGroup *sync.WaitGroup) {
+	defer doneGroup.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		servers, err := discoverer.Discover()
+		if err != nil {
+			log.Printf("Discovery for %s failed: %s\n", source, err.Error())
+		} else {
+			for name, serverConfig := range servers {
+				if messages := validateServerConfiguration(pool.config, serverConfig); len(messages) != 0 {
+					log.Printf("Ignoring discovered server %s: %s\n", name, strings.Join(messages, ", "))
+					delete(servers, name)
+				}
+			}
+			added, removed := pool.Sync(source, servers)
+			for _, name := range added {
+				log.Printf("Discovered %s through %s\n", name, source)
+			}
+			for _, name := range removed {
+				log.Printf("%s is no longer provided by %s\n", name, source)
+			}
+			if detailed, ok := discoverer.(detailedDiscoverer); ok {
+				for name, details := range detailed.Details() {
+					// Servers that are defined elsewhere keep their details.
+					if _, owner, found := pool.Get(name); found && owner == source {
+						statusRegistryManager.SetDetails(name, details)
+					}
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			log.Printf("Discovery for %s received exit signal\n", source)
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// targetGroup is a single entry within a target file. The format is
+// compatible with Prometheus' file_sd_config.
+type targetGroup struct {
+	Targets []string          `yaml:"targets"`
+	Labels  map[string]string `yaml:"labels"`
+}
+
+// serverTemplateData is available within the name and check templates of
+// a discovered server.
+type serverTemplateData struct {
+	Target string
+	Labels map[string]string
+}
+
+// The FileDiscoverer turns every target listed in a set of JSON or YAML
+// target files into a server based on a check template.
+type FileDiscoverer struct {
+	config FileDiscoveryConfiguration
+	name   *template.Template
+}
+
+func NewFileDiscoverer(config FileDiscoveryConfiguration) (*FileDiscoverer, error) {
+	nameTemplate := config.Name
+	if nameTemplate == "" {
+		nameTemplate = DEFAULT_DISCOVERY_NAME
+	}
+	name, err := template.New("name").Option("missingkey=zero").Parse(nameTemplate)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := renderServerTemplate(config.Check, serverTemplateData{}); err != nil {
+		return nil, err
+	}
+	return &FileDiscoverer{config: config, name: name}, nil
+}
+
+func (d *FileDiscoverer) Discover() (map[string]ServerConfiguration, error) {
+	var paths []string
+	for _, pattern := range d.config.Files {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	sort.Strings(paths)
+	servers := make(map[string]ServerConfiguration)
+	for _, path := range paths {
+		rawData, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var groups []targetGroup
+		if err := yaml.Unmarshal(rawData, &groups); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err.Error())
+		}
+		for _, group := range groups {
+			for _, target := range group.Targets {
+				data := serverTemplateData{Target: target, Labels: group.Labels}
+				var name bytes.Buffer
+				if err := d.name.Execute(&name, data); err != nil {
+					return nil, fmt.Errorf("%s: %s", path, err.Error())
+				}
+				serverConfig, err := renderServerTemplate(d.config.Check, data)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %s", path, err.Error())
+				}
+				if _, found := servers[name.String()]; found {
+					log.Printf("%s: ignoring duplicate target %s\n", path, name.String())
+					continue
+				}
+				servers[name.String()] = serverConfig
+			}
+		}
+	}
+	return servers, nil
+}
+
+// renderServerTemplate executes the templates within the isAliveUrl, group,
+// headers and basic auth credentials of the given server configuration.
+func renderServerTemplate(check ServerConfiguration, data interface{}) (ServerConfiguration, error) {
+	var err error
+	render := func(value string) string {
+		if err != nil {
+			return ""
+		}
+		var tmpl *template.Template
+		tmpl, err = template.New("check").Option("missingkey=zero").Parse(value)
+		if err != nil {
+			return ""
+		}
+		var result bytes.Buffer
+		err = tmpl.Execute(&result, data)
+		return result.String()
+	}
+	result := check
+	result.IsAliveUrl = render(check.IsAliveUrl)
+	result.Group = render(check.Group)
+	if check.Headers != nil {
+		result.Headers = make(map[string]string)
+		for name, value := range check.Headers {
+			result.Headers[name] = render(value)
+		}
+	}
+	if check.BasicAuth != nil {
+		result.BasicAuth = &BasicAuthConfiguration{
+			Username: render(check.BasicAuth.Username),
+			Password: render(check.BasicAuth.Password),
+		}
+	}
+	return result, err
+}
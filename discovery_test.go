@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFileDiscovererDiscover(t *testing.T) {
+	dir := t.TempDir()
+	ioutil.WriteFile(filepath.Join(dir, "web.json"), []byte(`[{"targets": ["10.0.0.1:8080", "10.0.0.2:8080"], "labels": {"env": "prod"}}]`), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "db.yaml"), []byte("- targets:\n    - 10.0.1.1:5432\n"), 0644)
+	discoverer, err := NewFileDiscoverer(FileDiscoveryConfiguration{
+		Files: []string{filepath.Join(dir, "*.json"), filepath.Join(dir, "*.yaml")},
+		Name:  "{{with .Labels.env}}{{.}}-{{end}}{{.Target}}",
+		Check: ServerConfiguration{
+			IsAliveUrl: "http://{{.Target}}/health",
+			Headers:    map[string]string{"X-Env": "{{.Labels.env}}"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers, err := discoverer.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 3 {
+		t.Fatalf("Expected 3 servers, got %v", servers)
+	}
+	if server := servers["prod-10.0.0.2:8080"]; server.IsAliveUrl != "http://10.0.0.2:8080/health" || server.Headers["X-Env"] != "prod" {
+		t.Errorf("Unexpected server %v", server)
+	}
+	if server, found := servers["10.0.1.1:5432"]; !found || server.Headers["X-Env"] != "" {
+		t.Errorf("Unexpected server %v", server)
+	}
+}
+
+func TestServerPoolSync(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	doneGroup := &sync.WaitGroup{}
+	pool := NewServerPool(ctx, &Configuration{}, make(chan StatusUpdate, 10), doneGroup)
+	defer func() {
+		cancel()
+		doneGroup.Wait()
+	}()
+	pool.Add("static", SOURCE_CONFIG, ServerConfiguration{IsAliveUrl: "http://localhost:1/"})
+
+	added, removed := pool.Sync("files", map[string]ServerConfiguration{
+		"a":      {IsAliveUrl: "http://localhost:1/a"},
+		"b":      {IsAliveUrl: "http://localhost:1/b"},
+		"static": {IsAliveUrl: "http://localhost:1/static"},
+	})
+	if len(added) != 2 || added[0] != "a" || added[1] != "b" || len(removed) != 0 {
+		t.Errorf("Unexpected changes: added %v, removed %v", added, removed)
+	}
+	if config, source, _ := pool.Get("static"); source != SOURCE_CONFIG || config.IsAliveUrl != "http://localhost:1/" {
+		t.Error("Sync must not touch servers of other sources")
+	}
+
+	added, removed = pool.Sync("files", map[string]ServerConfiguration{
+		"b": {IsAliveUrl: "http://localhost:1/b2"},
+	})
+	if len(added) != 0 || len(removed) != 1 || removed[0] != "a" {
+		t.Errorf("Unexpected changes: added %v, removed %v", added, removed)
+	}
+	if config, _, _ := pool.Get("b"); config.IsAliveUrl != "http://localhost:1/b2" {
+		t.Errorf("Expected b to be updated, got %v", config)
+	}
+	if pool.Len() != 2 {
+		t.Errorf("Expected 2 servers, got %d", pool.Len())
+	}
+
+	if err := pool.Set("b", SOURCE_API, ServerConfiguration{IsAliveUrl: "http://localhost:1/api"}); err == nil {
+		t.Error("Expected discovered servers not to be replaced")
+	}
+	if found, err := pool.Remove("b", SOURCE_API); !found || err == nil {
+		t.Error("Expected discovered servers not to be removed")
+	}
+	if config, source, _ := pool.Get("b"); source != "files" || config.IsAliveUrl != "http://localhost:1/b2" {
+		t.Errorf("Expected b to be kept, got %v from %s", config, source)
+	}
+}
+
+func TestServerPoolScheduledFirstCheck(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	doneGroup := &sync.WaitGroup{}
+	pool := NewServerPool(ctx, &Configuration{}, make(chan StatusUpdate, 10), doneGroup)
+	defer func() {
+		cancel()
+		doneGroup.Wait()
+	}()
+	defer statusRegistryManager.RemoveStatus("yearly")
+	// Checked at midnight on the first of January only.
+	pool.Add("yearly", SOURCE_CONFIG, ServerConfiguration{IsAliveUrl: "http://localhost:1/", Schedule: "0 0 1 1 *"})
+	pool.lock.RLock()
+	job := pool.workers["yearly"].job
+	pool.lock.RUnlock()
+	pool.scheduler.lock.Lock()
+	nextRun := job.nextRun
+	pool.scheduler.lock.Unlock()
+	next := time.Date(time.Now().Year()+1, time.January, 1, 0, 0, 0, 0, time.Local)
+	if difference := nextRun.Sub(next); difference < 0 || difference > time.Second {
+		t.Errorf("Expected the first check at %v, got %v", next, nextRun)
+	}
+}
+
+type staticDiscoverer struct {
+	servers map[string]ServerConfiguration
+	details map[string]map[string]string
+}
+
+func (d *staticDiscoverer) Discover() (map[string]ServerConfiguration, error) {
+	servers := make(map[string]ServerConfiguration)
+	for name, config := range d.servers {
+		servers[name] = config
+	}
+	return servers, nil
+}
+
+func (d *staticDiscoverer) Details() map[string]map[string]string {
+	return d.details
+}
+
+func TestDiscoveryHandlerDetails(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	doneGroup := &sync.WaitGroup{}
+	pool := NewServerPool(ctx, &Configuration{}, make(chan StatusUpdate, 10), doneGroup)
+	defer func() {
+		cancel()
+		doneGroup.Wait()
+	}()
+	pool.Add("details-static", SOURCE_CONFIG, ServerConfiguration{IsAliveUrl: "http://localhost:1/"})
+	defer statusRegistryManager.RemoveStatus("details-static")
+	defer statusRegistryManager.RemoveStatus("details-found")
+	discoverer := &staticDiscoverer{
+		servers: map[string]ServerConfiguration{
+			"details-static": {IsAliveUrl: "http://localhost:1/container"},
+			"details-found":  {IsAliveUrl: "http://localhost:1/found"},
+		},
+		details: map[string]map[string]string{
+			"details-static": {"image": "nginx"},
+			"details-found":  {"image": "nginx"},
+		},
+	}
+
+	// The handler runs a single discovery if its context is already done.
+	handlerCtx, handlerCancel := context.WithCancel(context.Background())
+	handlerCancel()
+	handlerDone := &sync.WaitGroup{}
+	handlerDone.Add(1)
+	DiscoveryHandler(handlerCtx, "docker discovery", discoverer, DEFAULT_DISCOVERY_REFRESH_INTERVAL, pool, handlerDone)
+
+	if status, _ := statusRegistryManager.Get("details-found"); status.Details["image"] != "nginx" {
+		t.Errorf("Expected the details of a discovered server, got %v", status.Details)
+	}
+	if status, _ := statusRegistryManager.Get("details-static"); status.Details != nil {
+		t.Errorf("Expected a configured server to keep its details, got %v", status.Details)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DEFAULT_DOCKER_SOCKET = "/var/run/docker.sock"
+	DOCKER_LABEL_PREFIX   = "statusd."
+)
+
+type dockerContainer struct {
+	Id     string
+	Names  []string
+	Image  string
+	State  string
+	Labels map[string]string
+}
+
+type dockerContainerDetails struct {
+	RestartCount int
+	State        struct {
+		Status string
+		Health *struct {
+			Status string
+		}
+	}
+}
+
+// The DockerDiscoverer talks to the local Docker engine and checks every
+// running container that has a "statusd.url" label. The check can be
+// customized through additional labels:
+//
+//	statusd.url       the isAliveUrl of the container (required)
+//	statusd.name      name of the server (default: the container's name)
+//	statusd.timeout   timeout of a single check
+//	statusd.delay     delay between two checks
+//	statusd.group     group the server belongs to
+//	statusd.tags      comma separated list of tags
+type DockerDiscoverer struct {
+	client  *http.Client
+	details map[string]map[string]string
+}
+
+func NewDockerDiscoverer(config DockerDiscoveryConfiguration) *DockerDiscoverer {
+	socket := config.Socket
+	if socket == "" {
+		socket = DEFAULT_DOCKER_SOCKET
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &DockerDiscoverer{client: &http.Client{Transport: transport, Timeout: 10 * time.Second}}
+}
+
+func (d *DockerDiscoverer) Discover() (map[string]ServerConfiguration, error) {
+	filters, _ := json.Marshal(map[string][]string{"label": {DOCKER_LABEL_PREFIX + "url"}})
+	var containers []dockerContainer
+	if err := d.get("/containers/json?filters="+url.QueryEscape(string(filters)), &containers); err != nil {
+		return nil, err
+	}
+	servers := make(map[string]ServerConfiguration)
+	details := make(map[string]map[string]string)
+	for _, container := range containers {
+		name, serverConfig, err := dockerServerConfiguration(container)
+		if err != nil {
+			log.Printf("Ignoring container %s: %s\n", container.Id, err.Error())
+			continue
+		}
+		if other, found := details[name]; found {
+			log.Printf("Ignoring container %s: %s is already provided by container %s\n", container.Id, name, other["container"])
+			continue
+		}
+		var containerDetails dockerContainerDetails
+		if err := d.get("/containers/"+container.Id+"/json", &containerDetails); err != nil {
+			// The container might have been removed in the meantime.
+			log.Printf("Failed to inspect container %s: %s\n", container.Id, err.Error())
+			continue
+		}
+		health := "none"
+		if containerDetails.State.Health != nil {
+			health = containerDetails.State.Health.Status
+		}
+		containerId := container.Id
+		if len(containerId) > 12 {
+			containerId = containerId[:12]
+		}
+		servers[name] = serverConfig
+		details[name] = map[string]string{
+			"container":    containerId,
+			"image":        container.Image,
+			"state":        containerDetails.State.Status,
+			"health":       health,
+			"restartCount": strconv.Itoa(containerDetails.RestartCount),
+		}
+	}
+	d.details = details
+	return servers, nil
+}
+
+// Details returns information about the container behind every server
+// found by the last call to Discover.
+func (d *DockerDiscoverer) Details() map[string]map[string]string {
+	return d.details
+}
+
+func (d *DockerDiscoverer) get(path string, result interface{}) error {
+	resp, err := d.client.Get("http://docker" + path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Docker engine returned %s for %s", resp.Status, path)
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// dockerServerConfiguration builds the server configuration for a container
+// from its labels.
+func dockerServerConfiguration(container dockerContainer) (string, ServerConfiguration, error) {
+	labels := container.Labels
+	name := labels[DOCKER_LABEL_PREFIX+"name"]
+	if name == "" && len(container.Names) != 0 {
+		name = strings.TrimPrefix(container.Names[0], "/")
+	}
+	if name == "" {
+		name = container.Id
+	}
+	serverConfig := ServerConfiguration{
+		IsAliveUrl: labels[DOCKER_LABEL_PREFIX+"url"],
+		Group:      labels[DOCKER_LABEL_PREFIX+"group"],
+	}
+	for _, tag := range strings.Split(labels[DOCKER_LABEL_PREFIX+"tags"], ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			serverConfig.Tags = append(serverConfig.Tags, tag)
+		}
+	}
+	var err error
+	if value, found := labels[DOCKER_LABEL_PREFIX+"timeout"]; found {
+		if serverConfig.Timeout, err = parseDuration(value); err != nil {
+			return name, serverConfig, err
+		}
+	}
+	if value, found := labels[DOCKER_LABEL_PREFIX+"delay"]; found {
+		if serverConfig.Delay, err = parseDuration(value); err != nil {
+			return name, serverConfig, err
+		}
+	}
+	return name, serverConfig, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDockerDiscovererDiscover(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("filters") != `{"label":["statusd.url"]}` {
+			t.Errorf("Unexpected filters %s", r.URL.Query().Get("filters"))
+		}
+		w.Write([]byte(`[
+			{"Id": "0123456789abcdef", "Names": ["/web"], "Image": "nginx", "Labels": {"statusd.url": "http://web/", "statusd.delay": "10"}},
+			{"Id": "fedcba9876543210", "Names": ["/worker"], "Image": "worker", "Labels": {"statusd.url": "http://worker/", "statusd.name": "jobs", "statusd.timeout": "500ms"}},
+			{"Id": "badbadbadbadbad0", "Names": ["/broken"], "Labels": {"statusd.url": "http://broken/", "statusd.delay": "soon"}},
+			{"Id": "0000000000000000", "Names": ["/web-copy"], "Image": "nginx", "Labels": {"statusd.url": "http://web-copy/", "statusd.name": "web"}}
+		]`))
+	})
+	mux.HandleFunc("/containers/0123456789abcdef/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"RestartCount": 3, "State": {"Status": "running", "Health": {"Status": "healthy"}}}`))
+	})
+	mux.HandleFunc("/containers/fedcba9876543210/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"RestartCount": 0, "State": {"Status": "running"}}`))
+	})
+	mux.HandleFunc("/containers/0000000000000000/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"RestartCount": 0, "State": {"Status": "running"}}`))
+	})
+	server := httptest.NewUnstartedServer(mux)
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	discoverer := NewDockerDiscoverer(DockerDiscoveryConfiguration{Socket: socket})
+	servers, err := discoverer.Discover()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("Expected 2 servers, got %v", servers)
+	}
+	if web := servers["web"]; web.IsAliveUrl != "http://web/" || web.Delay != Duration(10*time.Second) {
+		t.Errorf("Unexpected server %v", web)
+	}
+	if jobs := servers["jobs"]; jobs.IsAliveUrl != "http://worker/" || jobs.Timeout != Duration(500*time.Millisecond) {
+		t.Errorf("Unexpected server %v", jobs)
+	}
+	details := discoverer.Details()
+	if web := details["web"]; web["health"] != "healthy" || web["restartCount"] != "3" || web["container"] != "0123456789ab" {
+		t.Errorf("Unexpected details %v", web)
+	}
+	if jobs := details["jobs"]; jobs["health"] != "none" {
+		t.Errorf("Unexpected details %v", jobs)
+	}
+}
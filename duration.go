@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Duration is a time.Duration that can be configured either as a Go
+// duration string (e.g. "500ms" or "2m") or as a plain number of seconds.
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func parseDuration(value interface{}) (Duration, error) {
+	switch v := value.(type) {
+	case int:
+		return Duration(time.Duration(v) * time.Second), nil
+	case float64:
+		return Duration(v * float64(time.Second)), nil
+	case string:
+		if seconds, err := strconv.Atoi(v); err == nil {
+			return Duration(time.Duration(seconds) * time.Second), nil
+		}
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", v)
+		}
+		return Duration(parsed), nil
+	case nil:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("invalid duration %v", value)
+}
+
+func (d *Duration) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var raw interface{}
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	parsed, err := parseDuration(raw)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func (d Duration) MarshalYAML() (interface{}, error) {
+	return d.String(), nil
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var raw interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	parsed, err := parseDuration(raw)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
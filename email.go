@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	EMAIL_STARTTLS_AUTO     = "auto"
+	EMAIL_STARTTLS_REQUIRED = "required"
+	EMAIL_STARTTLS_DISABLED = "disabled"
+	EMAIL_AUTH_PLAIN        = "plain"
+	EMAIL_AUTH_LOGIN        = "login"
+)
+
+const (
+	DEFAULT_EMAIL_PORT         = 587
+	DEFAULT_EMAIL_BATCH_WINDOW = 5 * time.Second
+)
+
+const (
+	DEFAULT_EMAIL_SUBJECT = `{{if eq (len .Messages) 1}}{{(index .Messages 0).Title}}{{else}}{{len .Messages}} status changes{{end}}`
+
+	DEFAULT_EMAIL_TEXT_TEMPLATE = `{{range .Messages}}{{.Title}}
+{{range .Fields}}
+{{index . 0}}: {{index . 1}}{{end}}
+{{with .DashboardUrl}}
+Dashboard: {{.}}
+{{end}}
+{{end}}`
+
+	DEFAULT_EMAIL_HTML_TEMPLATE = `<html>
+<body>
+{{range .Messages}}<h2 style="color: {{if .Online}}#2eb886{{else}}#d50200{{end}}">{{.Title}}</h2>
+<table>
+{{range .Fields}}<tr><th align="left">{{index . 0}}</th><td>{{index . 1}}</td></tr>
+{{end}}</table>
+{{with .DashboardUrl}}<p><a href="{{.}}">Open dashboard</a></p>
+{{end}}{{end}}</body>
+</html>
+`
+)
+
+// emailData is passed to the templates of email notifiers.
+type emailData struct {
+	Messages []Message
+}
+
+// The EmailNotifier sends status changes via SMTP. Changes that happen at
+// the same time are batched into a single mail per group of recipients.
+type EmailNotifier struct {
+	config       EmailNotifierConfiguration
+	from         *mail.Address
+	subject      *template.Template
+	textTemplate *template.Template
+	htmlTemplate *htmltemplate.Template
+}
+
+func NewEmailNotifier(config EmailNotifierConfiguration) (*EmailNotifier, error) {
+	if config.Host == "" {
+		return nil, fmt.Errorf("email notifiers require a host")
+	}
+	if config.Port == 0 {
+		config.Port = DEFAULT_EMAIL_PORT
+	}
+	if config.Port < 0 || config.Port > 65535 {
+		return nil, fmt.Errorf("invalid port %d", config.Port)
+	}
+	switch config.StartTLS {
+	case "":
+		config.StartTLS = EMAIL_STARTTLS_AUTO
+	case EMAIL_STARTTLS_AUTO, EMAIL_STARTTLS_REQUIRED, EMAIL_STARTTLS_DISABLED:
+	default:
+		return nil, fmt.Errorf("startTLS has to be %s, %s or %s", EMAIL_STARTTLS_AUTO, EMAIL_STARTTLS_REQUIRED, EMAIL_STARTTLS_DISABLED)
+	}
+	switch config.Auth {
+	case "":
+		config.Auth = EMAIL_AUTH_PLAIN
+	case EMAIL_AUTH_PLAIN, EMAIL_AUTH_LOGIN:
+	default:
+		return nil, fmt.Errorf("auth has to be %s or %s", EMAIL_AUTH_PLAIN, EMAIL_AUTH_LOGIN)
+	}
+	if config.BatchWindow < 0 {
+		return nil, fmt.Errorf("batchWindow must not be negative")
+	}
+	from, err := mail.ParseAddress(config.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender %q: %s", config.From, err.Error())
+	}
+	if len(config.To) == 0 && len(config.Recipients) == 0 {
+		return nil, fmt.Errorf("email notifiers require at least one recipient")
+	}
+	for _, recipients := range append([][]string{config.To}, recipientLists(config.Recipients)...) {
+		for _, recipient := range recipients {
+			if _, err := mail.ParseAddress(recipient); err != nil {
+				return nil, fmt.Errorf("invalid recipient %q: %s", recipient, err.Error())
+			}
+		}
+	}
+	n := &EmailNotifier{config: config, from: from}
+	if n.subject, err = template.New("subject").Parse(firstNonEmpty(config.Subject, DEFAULT_EMAIL_SUBJECT)); err != nil {
+		return nil, fmt.Errorf("invalid subject: %s", err.Error())
+	}
+	if n.textTemplate, err = template.New("text").Parse(firstNonEmpty(config.TextTemplate, DEFAULT_EMAIL_TEXT_TEMPLATE)); err != nil {
+		return nil, fmt.Errorf("invalid textTemplate: %s", err.Error())
+	}
+	if n.htmlTemplate, err = htmltemplate.New("html").Parse(firstNonEmpty(config.HtmlTemplate, DEFAULT_EMAIL_HTML_TEMPLATE)); err != nil {
+		return nil, fmt.Errorf("invalid htmlTemplate: %s", err.Error())
+	}
+	return n, nil
+}
+
+func recipientLists(recipients map[string][]string) [][]string {
+	var result [][]string
+	for _, list := range recipients {
+		result = append(result, list)
+	}
+	return result
+}
+
+func (n *EmailNotifier) BatchWindow() time.Duration {
+	if n.config.BatchWindow == 0 {
+		return DEFAULT_EMAIL_BATCH_WINDOW
+	}
+	return time.Duration(n.config.BatchWindow)
+}
+
+func (n *EmailNotifier) Notify(ctx context.Context, message Message) error {
+	return n.NotifyBatch(ctx, []Message{message})
+}
+
+// NotifyBatch sends one mail for every distinct set of recipients.
+func (n *EmailNotifier) NotifyBatch(ctx context.Context, messages []Message) error {
+	var keys []string
+	recipientsByKey := make(map[string][]string)
+	messagesByKey := make(map[string][]Message)
+	for _, message := range messages {
+		recipients := n.recipients(message.ServerName)
+		if len(recipients) == 0 {
+			continue
+		}
+		key := strings.Join(recipients, ",")
+		if _, found := recipientsByKey[key]; !found {
+			keys = append(keys, key)
+			recipientsByKey[key] = recipients
+		}
+		messagesByKey[key] = append(messagesByKey[key], message)
+	}
+	for _, key := range keys {
+		body, err := n.render(recipientsByKey[key], messagesByKey[key])
+		if err != nil {
+			return err
+		}
+		if err := n.send(ctx, recipientsByKey[key], body); err != nil {
+			return fmt.Errorf("Sending mail to %s failed: %s", key, err.Error())
+		}
+	}
+	return nil
+}
+
+// recipients returns the sorted addresses that are notified about the
+// given server.
+func (n *EmailNotifier) recipients(serverName string) []string {
+	unique := stringSet(append(append([]string(nil), n.config.To...), n.config.Recipients[serverName]...))
+	result := make([]string, 0, len(unique))
+	for recipient := range unique {
+		result = append(result, recipient)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// render builds a multipart/alternative mail including its headers.
+func (n *EmailNotifier) render(recipients []string, messages []Message) ([]byte, error) {
+	data := emailData{Messages: messages}
+	var subject, text, html bytes.Buffer
+	if err := n.subject.Execute(&subject, data); err != nil {
+		return nil, err
+	}
+	if err := n.textTemplate.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := n.htmlTemplate.Execute(&html, data); err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{{"text/plain", text.Bytes()}, {"text/html", html.Bytes()}} {
+		writer, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoder := quotedprintable.NewWriter(writer)
+		encoder.Write(part.content)
+		encoder.Close()
+	}
+	parts.Close()
+
+	var result bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&result, "%s: %s\r\n", name, value)
+	}
+	header("From", n.from.String())
+	header("To", strings.Join(recipients, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", strings.TrimSpace(subject.String())))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("Message-ID", n.messageID())
+	header("MIME-Version", "1.0")
+	header("Content-Type", "multipart/alternative; boundary="+parts.Boundary())
+	result.WriteString("\r\n")
+	result.Write(body.Bytes())
+	return result.Bytes(), nil
+}
+
+func (n *EmailNotifier) messageID() string {
+	random := make([]byte, 16)
+	rand.Read(random)
+	domain := n.from.Address[strings.LastIndex(n.from.Address, "@")+1:]
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(random), domain)
+}
+
+// send delivers the mail. net/smtp doesn't support contexts, so the
+// context's deadline is applied to the connection instead.
+func (n *EmailNotifier) send(ctx context.Context, recipients []string, body []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(n.config.Host, strconv.Itoa(n.config.Port)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, n.config.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if n.config.StartTLS != EMAIL_STARTTLS_DISABLED {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			tlsConfig := &tls.Config{ServerName: n.config.Host, InsecureSkipVerify: n.config.InsecureSkipVerify}
+			if err := client.StartTLS(tlsConfig); err != nil {
+				return err
+			}
+		} else if n.config.StartTLS == EMAIL_STARTTLS_REQUIRED {
+			return errors.New("the server doesn't support STARTTLS")
+		}
+	}
+	if n.config.Username != "" {
+		var auth smtp.Auth
+		if n.config.Auth == EMAIL_AUTH_LOGIN {
+			auth = &loginAuth{n.config.Host, n.config.Username, n.config.Password}
+		} else {
+			auth = smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)
+		}
+		if err := client.Auth(auth); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(n.from.Address); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		address, _ := mail.ParseAddress(recipient)
+		if err := client.Rcpt(address.Address); err != nil {
+			return err
+		}
+	}
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := writer.Write(body); err != nil {
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// loginAuth implements the LOGIN mechanism, which isn't part of net/smtp
+// but still required by some servers. Like smtp.PlainAuth, it refuses to
+// send credentials over unencrypted connections except to localhost.
+type loginAuth struct {
+	host, username, password string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	}
+	return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type receivedMail struct {
+	auth       string
+	from       string
+	recipients []string
+	data       string
+}
+
+// fakeSMTPServer accepts mails without STARTTLS and records them.
+type fakeSMTPServer struct {
+	listener net.Listener
+	lock     sync.Mutex
+	mails    []receivedMail
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &fakeSMTPServer{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.handle(conn)
+		}
+	}()
+	return server
+}
+
+func (s *fakeSMTPServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTPServer) received() []receivedMail {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]receivedMail(nil), s.mails...)
+}
+
+func (s *fakeSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(format string, args ...interface{}) {
+		fmt.Fprintf(conn, format+"\r\n", args...)
+	}
+	readLine := func() string {
+		line, _ := reader.ReadString('\n')
+		return strings.TrimRight(line, "\r\n")
+	}
+	var current receivedMail
+	reply("220 localhost ESMTP")
+	for {
+		line := readLine()
+		command := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch command {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN LOGIN")
+		case "AUTH":
+			if strings.HasPrefix(line, "AUTH LOGIN") {
+				reply("334 %s", base64.StdEncoding.EncodeToString([]byte("Username:")))
+				username, _ := base64.StdEncoding.DecodeString(readLine())
+				reply("334 %s", base64.StdEncoding.EncodeToString([]byte("Password:")))
+				password, _ := base64.StdEncoding.DecodeString(readLine())
+				current.auth = "LOGIN " + string(username) + ":" + string(password)
+			} else {
+				credentials, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+				current.auth = "PLAIN " + strings.Replace(strings.TrimPrefix(string(credentials), "\x00"), "\x00", ":", 1)
+			}
+			reply("235 OK")
+		case "MAIL":
+			current.from = strings.TrimSuffix(strings.TrimPrefix(line, "MAIL FROM:<"), ">")
+			reply("250 OK")
+		case "RCPT":
+			current.recipients = append(current.recipients, strings.TrimSuffix(strings.TrimPrefix(line, "RCPT TO:<"), ">"))
+			reply("250 OK")
+		case "DATA":
+			reply("354 Go ahead")
+			var data []string
+			for line := readLine(); line != "."; line = readLine() {
+				data = append(data, line)
+			}
+			current.data = strings.Join(data, "\r\n")
+			s.lock.Lock()
+			s.mails = append(s.mails, current)
+			s.lock.Unlock()
+			current = receivedMail{auth: current.auth}
+			reply("250 OK")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		case "":
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestEmailNotifierBatch(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	defer server.listener.Close()
+	notifier, err := NewEmailNotifier(EmailNotifierConfiguration{
+		Host:       "127.0.0.1",
+		Port:       server.port(),
+		Username:   "statusd",
+		Password:   "secret",
+		Auth:       EMAIL_AUTH_LOGIN,
+		From:       "StatusD <statusd@example.com>",
+		To:         []string{"ops@example.com"},
+		Recipients: map[string][]string{"checkout": {"management@example.com"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = notifier.NotifyBatch(context.Background(), []Message{
+		{ServerName: "web", Status: STATUS_OFFLINE, Reason: "timeout"},
+		{ServerName: "checkout", Status: STATUS_OFFLINE, Reason: "<b>500</b>"},
+		{ServerName: "db", Status: STATUS_ONLINE, Downtime: time.Minute},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mails := server.received()
+	if len(mails) != 2 {
+		t.Fatalf("Expected a mail per group of recipients, got %d", len(mails))
+	}
+	if mails[0].auth != "LOGIN statusd:secret" || mails[0].from != "statusd@example.com" || strings.Join(mails[0].recipients, ",") != "ops@example.com" {
+		t.Errorf("Unexpected envelope %v", mails[0])
+	}
+	if strings.Join(mails[1].recipients, ",") != "management@example.com,ops@example.com" {
+		t.Errorf("Unexpected recipients %v", mails[1].recipients)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(mails[0].data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subject := parsed.Header.Get("Subject"); subject != "2 status changes" {
+		t.Errorf("Unexpected subject %s", subject)
+	}
+	text, html := readEmailParts(t, parsed)
+	for _, expected := range []string{"web is now offline", "Reason: timeout", "db is now online", "Offline for: 1m0s"} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("Expected %q in text %s", expected, text)
+		}
+	}
+	if !strings.Contains(html, "<h2 style=\"color: #d50200\">web is now offline</h2>") {
+		t.Errorf("Unexpected HTML %s", html)
+	}
+
+	parsed, _ = mail.ReadMessage(strings.NewReader(mails[1].data))
+	if subject := parsed.Header.Get("Subject"); subject != "checkout is now offline" {
+		t.Errorf("Unexpected subject %s", subject)
+	}
+	if _, html := readEmailParts(t, parsed); !strings.Contains(html, "&lt;b&gt;500&lt;/b&gt;") {
+		t.Errorf("Expected the reason to be escaped, got %s", html)
+	}
+}
+
+func readEmailParts(t *testing.T, message *mail.Message) (string, string) {
+	_, params, _ := mime.ParseMediaType(message.Header.Get("Content-Type"))
+	reader := multipart.NewReader(message.Body, params["boundary"])
+	var parts []string
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		content, _ := ioutil.ReadAll(part)
+		parts = append(parts, string(content))
+	}
+	if len(parts) != 2 {
+		t.Fatalf("Expected a text and a HTML part, got %d parts", len(parts))
+	}
+	return parts[0], parts[1]
+}
+
+func TestEmailNotifierBatchWindow(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	defer server.listener.Close()
+	notifier, err := NewEmailNotifier(EmailNotifierConfiguration{
+		Host:        "127.0.0.1",
+		Port:        server.port(),
+		StartTLS:    EMAIL_STARTTLS_AUTO,
+		From:        "statusd@example.com",
+		To:          []string{"ops@example.com"},
+		BatchWindow: Duration(50 * time.Millisecond),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, _ := NewNotificationDispatcher(&Configuration{})
+	d.Add("mail", notifier, serverFilter{})
+	d.Start()
+	d.Dispatch(Event{StatusUpdate: StatusUpdate{ServerName: "web", Status: STATUS_OFFLINE}})
+	d.Dispatch(Event{StatusUpdate: StatusUpdate{ServerName: "db", Status: STATUS_OFFLINE}})
+	deadline := time.Now().Add(time.Second)
+	for len(server.received()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	d.Close(time.Second)
+	if mails := server.received(); len(mails) != 1 || !strings.Contains(mails[0].data, "Subject: 2 status changes") {
+		t.Errorf("Expected both changes in a single mail, got %v", mails)
+	}
+}
+
+func TestNewEmailNotifierValidation(t *testing.T) {
+	valid := EmailNotifierConfiguration{Host: "smtp.example.com", From: "statusd@example.com", To: []string{"ops@example.com"}}
+	if _, err := NewEmailNotifier(valid); err != nil {
+		t.Fatal(err)
+	}
+	for _, change := range []func(*EmailNotifierConfiguration){
+		func(c *EmailNotifierConfiguration) { c.Host = "" },
+		func(c *EmailNotifierConfiguration) { c.From = "" },
+		func(c *EmailNotifierConfiguration) { c.To = nil },
+		func(c *EmailNotifierConfiguration) { c.Recipients = map[string][]string{"web": {"not an address"}} },
+		func(c *EmailNotifierConfiguration) { c.StartTLS = "maybe" },
+		func(c *EmailNotifierConfiguration) { c.Auth = "cram-md5" },
+		func(c *EmailNotifierConfiguration) { c.HtmlTemplate = "{{range}}" },
+	} {
+		config := valid
+		change(&config)
+		if _, err := NewEmailNotifier(config); err == nil {
+			t.Errorf("Expected %v to be invalid", config)
+		}
+	}
+}
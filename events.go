@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const SSE_HEARTBEAT_INTERVAL = 30 * time.Second
+
+// eventEpoch identifies the current run of statusd. Event IDs start from
+// scratch with every run, so the epoch is part of the IDs sent to clients.
+var eventEpoch = strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// httpEventsHandler streams status updates as Server-Sent Events. Every
+// event has the type "status" and the same JSON payload as the updates of
+// the websocket feed. Like the websocket, the stream can be filtered using
+// the "servers" and "tags" query parameters.
+//
+// Clients that pass the ID of the last event they have seen (either through
+// the Last-Event-ID header or the lastEventId query parameter) first receive
+// all events they have missed. If some of them are no longer kept in the
+// event log, an event of the type "gap" is sent before them. The same
+// happens if the client can't keep up with the events. Clients that pass
+// the ID of an event of a previous run of statusd receive the current status
+// of every server instead.
+func httpEventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	var lastSent uint64
+	resume := false
+	if lastEventID != "" {
+		epoch, id, err := parseEventID(lastEventID)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid event ID %q", lastEventID), http.StatusBadRequest)
+			return
+		}
+		resume = epoch == eventEpoch
+		lastSent = id
+	}
+	filter := newRequestServerFilter(r)
+	matches := func(event Event) bool {
+		return filter.Matches(event.ServerName, event.Tags)
+	}
+	// Subscribe before reading the event log so that no event is missed.
+	sub, unsubscribe := statusRegistryManager.subscribe(matches, 100)
+	defer unsubscribe()
+	if !resume {
+		lastSent = sub.since
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep reverse proxies like nginx from buffering the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// replay sends all matching events after lastSent from the event log.
+	replay := func() error {
+		backlog, complete := statusRegistryManager.EventsSince(lastSent)
+		if !complete {
+			if _, err := fmt.Fprint(w, "event: gap\ndata: {}\n\n"); err != nil {
+				return err
+			}
+		}
+		for _, event := range backlog {
+			if matches(event) {
+				if err := writeServerSentEvent(w, event); err != nil {
+					return err
+				}
+			}
+			lastSent = event.ID
+		}
+		return nil
+	}
+	// snapshot sends the current status of all matching servers.
+	snapshot := func() error {
+		statuses, id := statusRegistryManager.snapshot()
+		for _, status := range statuses {
+			if filter.Matches(status.ServerName, status.Tags) {
+				message := overviewMessage{"snapshot", status.ServerName, status.Status, status.Reason, status.Tags, status.Updated}
+				if err := writeServerSentMessage(w, id, message); err != nil {
+					return err
+				}
+			}
+		}
+		lastSent = id
+		return nil
+	}
+	if resume {
+		if err := replay(); err != nil {
+			return
+		}
+	} else if lastEventID != "" {
+		// The event IDs of a previous run are meaningless now.
+		if err := snapshot(); err != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	ticker := time.NewTicker(SSE_HEARTBEAT_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-sub.channel:
+			// If the client is too slow, events are dropped from the
+			// subscription. They are sent from the event log instead.
+			if sub.takeDropped() != 0 {
+				if err := replay(); err != nil {
+					return
+				}
+			}
+			// The event might already have been sent from the event log.
+			if event.ID <= lastSent {
+				break
+			}
+			if err := writeServerSentEvent(w, event); err != nil {
+				return
+			}
+			lastSent = event.ID
+		case <-ticker.C:
+			// A comment keeps proxies from closing an idle connection.
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeServerSentEvent(w http.ResponseWriter, event Event) error {
+	return writeServerSentMessage(w, event.ID, overviewMessage{"update", event.ServerName, event.Status, event.Reason, event.Tags, event.Time})
+}
+
+func writeServerSentMessage(w http.ResponseWriter, id uint64, message overviewMessage) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: status\ndata: %s\n\n", formatEventID(id), data)
+	return err
+}
+
+// formatEventID prefixes the given event ID with the epoch of the current
+// run.
+func formatEventID(id uint64) string {
+	return eventEpoch + "-" + strconv.FormatUint(id, 10)
+}
+
+// parseEventID splits an ID created by formatEventID into its epoch and
+// event ID. IDs without epoch (as sent by older versions) have an empty
+// epoch.
+func parseEventID(value string) (string, uint64, error) {
+	var epoch string
+	if idx := strings.LastIndex(value, "-"); idx != -1 {
+		epoch, value = value[:idx], value[idx+1:]
+	}
+	id, err := strconv.ParseUint(value, 10, 64)
+	return epoch, id, err
+}
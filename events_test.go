@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHttpEventsHandlerResume(t *testing.T) {
+	statusRegistryManager.SetStatus(StatusUpdate{ServerName: "sse-a", Status: STATUS_ONLINE})
+	events, _ := statusRegistryManager.EventsSince(0)
+	lastID := events[len(events)-1].ID
+	statusRegistryManager.SetStatus(StatusUpdate{ServerName: "sse-b", Status: STATUS_ONLINE})
+	statusRegistryManager.SetStatus(StatusUpdate{ServerName: "sse-a", Status: STATUS_OFFLINE, Reason: "timeout"})
+	defer statusRegistryManager.RemoveStatus("sse-a")
+	defer statusRegistryManager.RemoveStatus("sse-b")
+
+	server := httptest.NewServer(http.HandlerFunc(httpEventsHandler))
+	defer server.Close()
+	req, _ := http.NewRequest("GET", server.URL+"?servers=sse-a", nil)
+	req.Header.Set("Last-Event-ID", formatEventID(lastID))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("Unexpected content type %s", resp.Header.Get("Content-Type"))
+	}
+	reader := bufio.NewReader(resp.Body)
+	var lines []string
+	for len(lines) < 3 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	// The update of sse-b is filtered.
+	if lines[0] != "id: "+formatEventID(lastID+2) || lines[1] != "event: status" {
+		t.Errorf("Unexpected event %v", lines)
+	}
+	if !strings.Contains(lines[2], `"server":"sse-a"`) || !strings.Contains(lines[2], `"reason":"timeout"`) {
+		t.Errorf("Unexpected event data %s", lines[2])
+	}
+}
+
+// blockingResponseWriter stalls every write until release is closed.
+type blockingResponseWriter struct {
+	lock     sync.Mutex
+	recorder *httptest.ResponseRecorder
+	ready    chan struct{}
+	release  chan struct{}
+}
+
+func (w *blockingResponseWriter) Header() http.Header { return w.recorder.Header() }
+func (w *blockingResponseWriter) WriteHeader(code int) {
+	w.recorder.WriteHeader(code)
+	close(w.ready)
+}
+func (w *blockingResponseWriter) Flush() {}
+
+func (w *blockingResponseWriter) Write(data []byte) (int, error) {
+	<-w.release
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.recorder.Write(data)
+}
+
+func (w *blockingResponseWriter) body() string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.recorder.Body.String()
+}
+
+func TestHttpEventsHandlerOverflow(t *testing.T) {
+	defer statusRegistryManager.RemoveStatus("sse-overflow")
+	w := &blockingResponseWriter{recorder: httptest.NewRecorder(), ready: make(chan struct{}), release: make(chan struct{})}
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequest("GET", "/api/v1/events?servers=sse-overflow", nil)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		httpEventsHandler(w, req.WithContext(ctx))
+	}()
+	<-w.ready
+	// The client stalls while more events are published than fit into the
+	// subscription's buffer.
+	var last Event
+	for i := 0; i < 150; i++ {
+		last = statusRegistryManager.SetStatus(StatusUpdate{ServerName: "sse-overflow", Status: STATUS_ONLINE})
+	}
+	close(w.release)
+	lastLine := "id: " + formatEventID(last.ID) + "\n"
+	for deadline := time.Now().Add(5 * time.Second); !strings.Contains(w.body(), lastLine) && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	var ids []uint64
+	for _, line := range strings.Split(w.body(), "\n") {
+		if strings.HasPrefix(line, "event: gap") {
+			t.Error("Expected the dropped events to be replayed from the event log")
+		}
+		if strings.HasPrefix(line, "id: ") {
+			_, id, _ := parseEventID(strings.TrimPrefix(line, "id: "))
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) != 150 || ids[149] != last.ID {
+		t.Fatalf("Expected all 150 events, got %d", len(ids))
+	}
+	for i := 1; i < len(ids); i++ {
+		if ids[i] != ids[i-1]+1 {
+			t.Fatalf("Expected consecutive IDs, got %d after %d", ids[i], ids[i-1])
+		}
+	}
+}
+
+func TestHttpEventsHandlerRestart(t *testing.T) {
+	statusRegistryManager.SetStatus(StatusUpdate{ServerName: "sse-restart", Status: STATUS_OFFLINE, Reason: "timeout"})
+	defer statusRegistryManager.RemoveStatus("sse-restart")
+	server := httptest.NewServer(http.HandlerFunc(httpEventsHandler))
+	defer server.Close()
+
+	// The IDs were sent by a previous run of statusd.
+	for _, lastEventID := range []string{"previous-1", "1"} {
+		req, _ := http.NewRequest("GET", server.URL+"?servers=sse-restart", nil)
+		req.Header.Set("Last-Event-ID", lastEventID)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader := bufio.NewReader(resp.Body)
+		var lines []string
+		for len(lines) < 3 {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, strings.TrimSpace(line))
+		}
+		resp.Body.Close()
+		if epoch, _, _ := parseEventID(strings.TrimPrefix(lines[0], "id: ")); epoch != eventEpoch {
+			t.Errorf("Expected an ID of the current run, got %s", lines[0])
+		}
+		if !strings.Contains(lines[2], `"type":"snapshot"`) || !strings.Contains(lines[2], `"server":"sse-restart"`) || !strings.Contains(lines[2], `"reason":"timeout"`) {
+			t.Errorf("Expected a snapshot for %s, got %s", lastEventID, lines[2])
+		}
+	}
+
+	req, _ := http.NewRequest("GET", server.URL+"?lastEventId=previous-x", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid ID, got %d", resp.StatusCode)
+	}
+}
+
+func TestStatusRegistryManagerEventsSince(t *testing.T) {
+	m := NewStatusRegistryManager()
+	for i := 0; i < EVENT_LOG_SIZE+5; i++ {
+		m.SetStatus(StatusUpdate{ServerName: "server", Status: STATUS_ONLINE})
+	}
+	if events, complete := m.EventsSince(EVENT_LOG_SIZE); !complete || len(events) != 5 || events[0].ID != EVENT_LOG_SIZE+1 {
+		t.Errorf("Expected the last 5 events, got %d (complete: %v)", len(events), complete)
+	}
+	if events, complete := m.EventsSince(1); complete || len(events) != EVENT_LOG_SIZE {
+		t.Errorf("Expected an incomplete log of %d events, got %d (complete: %v)", EVENT_LOG_SIZE, len(events), complete)
+	}
+	if events, complete := m.EventsSince(EVENT_LOG_SIZE + 5); !complete || len(events) != 0 {
+		t.Errorf("Expected no events, got %d", len(events))
+	}
+	// IDs of a previous run are not trusted.
+	if _, complete := m.EventsSince(5000); complete {
+		t.Error("Expected unknown IDs to be reported as incomplete")
+	}
+}
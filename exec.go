@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// EXEC_OUTPUT_LOG_LIMIT is the number of bytes of a command's output
+	// that are logged.
+	EXEC_OUTPUT_LOG_LIMIT = 4096
+	// EXEC_WAIT_DELAY is how long statusd waits for the output of a command
+	// that has been killed, e.g. because it started background processes.
+	EXEC_WAIT_DELAY = time.Second
+)
+
+// The ExecNotifier runs a command for every status change. The change is
+// passed on stdin as the same JSON document that webhooks receive and
+// through environment variables. The output of the command is logged.
+type ExecNotifier struct {
+	config ExecNotifierConfiguration
+}
+
+func NewExecNotifier(config ExecNotifierConfiguration) (*ExecNotifier, error) {
+	if config.Command == "" {
+		return nil, fmt.Errorf("exec notifiers require a command")
+	}
+	if config.Timeout < 0 || time.Duration(config.Timeout) > NOTIFICATION_TIMEOUT {
+		return nil, fmt.Errorf("timeout has to be between 0 and %v", NOTIFICATION_TIMEOUT)
+	}
+	for name := range config.Env {
+		if name == "" || strings.Contains(name, "=") {
+			return nil, fmt.Errorf("invalid environment variable %q", name)
+		}
+	}
+	return &ExecNotifier{config: config}, nil
+}
+
+func (n *ExecNotifier) Notify(ctx context.Context, message Message) error {
+	if n.config.Timeout != 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(n.config.Timeout))
+		defer cancel()
+	}
+	input, err := json.Marshal(newWebhookPayload(message))
+	if err != nil {
+		return err
+	}
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, n.config.Command, n.config.Args...)
+	cmd.Dir = n.config.Dir
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.WaitDelay = EXEC_WAIT_DELAY
+	cmd.Env = append(os.Environ(), execEnvironment(message)...)
+	for name, value := range n.config.Env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	err = cmd.Run()
+	if output.Len() != 0 {
+		logged := output.String()
+		if len(logged) > EXEC_OUTPUT_LOG_LIMIT {
+			logged = logged[:EXEC_OUTPUT_LOG_LIMIT] + "..."
+		}
+		log.Printf("Output of %s for %s:\n%s\n", n.config.Command, message.ServerName, strings.TrimRight(logged, "\n"))
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%s timed out", n.config.Command)
+	}
+	if err != nil {
+		return fmt.Errorf("%s failed: %s", n.config.Command, err.Error())
+	}
+	return nil
+}
+
+// execEnvironment returns the environment variables describing the status
+// change.
+func execEnvironment(message Message) []string {
+	env := []string{
+		"STATUSD_SERVER=" + message.ServerName,
+		"STATUSD_STATUS=" + message.Status,
+		"STATUSD_PREVIOUS_STATUS=" + message.PreviousStatus,
+		"STATUSD_REASON=" + message.Reason,
+		"STATUSD_URL=" + message.Url,
+		"STATUSD_TAGS=" + strings.Join(message.Tags, ","),
+	}
+	if !message.Time.IsZero() {
+		env = append(env, "STATUSD_TIME="+message.Time.Format(time.RFC3339))
+	}
+	return env
+}
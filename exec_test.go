@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecNotifier(t *testing.T) {
+	dir := t.TempDir()
+	notifier, err := NewExecNotifier(ExecNotifierConfiguration{
+		Command: "/bin/sh",
+		Args:    []string{"-c", `cat > input.json; echo "$STATUSD_SERVER $STATUSD_STATUS $STATUSD_REASON $TEAM" > env; echo remediated`},
+		Dir:     dir,
+		Env:     map[string]string{"TEAM": "ops"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	if err := notifier.Notify(context.Background(), Message{ServerName: "web", Status: STATUS_OFFLINE, Reason: "timeout"}); err != nil {
+		t.Fatal(err)
+	}
+	var input webhookPayload
+	data, _ := ioutil.ReadFile(filepath.Join(dir, "input.json"))
+	if err := json.Unmarshal(data, &input); err != nil || input.Server != "web" || input.Status != STATUS_OFFLINE {
+		t.Errorf("Unexpected input %s", data)
+	}
+	if env, _ := ioutil.ReadFile(filepath.Join(dir, "env")); string(env) != "web offline timeout ops\n" {
+		t.Errorf("Unexpected environment %q", env)
+	}
+	if !strings.Contains(logged.String(), "remediated") {
+		t.Errorf("Expected the output to be logged, got %s", logged.String())
+	}
+}
+
+func TestExecNotifierFailure(t *testing.T) {
+	notifier, _ := NewExecNotifier(ExecNotifierConfiguration{Command: "/bin/sh", Args: []string{"-c", "exit 3"}})
+	if err := notifier.Notify(context.Background(), Message{}); err == nil || !strings.Contains(err.Error(), "exit status 3") {
+		t.Errorf("Expected the exit status to be reported, got %v", err)
+	}
+	notifier, _ = NewExecNotifier(ExecNotifierConfiguration{Command: "/bin/sh", Args: []string{"-c", "sleep 5"}, Timeout: Duration(50 * time.Millisecond)})
+	started := time.Now()
+	if err := notifier.Notify(context.Background(), Message{}); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 2*time.Second {
+		t.Errorf("Expected the command to be killed, took %v", elapsed)
+	}
+}
+
+func TestNewExecNotifierValidation(t *testing.T) {
+	for _, config := range []ExecNotifierConfiguration{
+		{},
+		{Command: "/bin/true", Timeout: Duration(time.Hour)},
+		{Command: "/bin/true", Env: map[string]string{"A=B": "C"}},
+	} {
+		if _, err := NewExecNotifier(config); err == nil {
+			t.Errorf("Expected %v to be invalid", config)
+		}
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -14,13 +17,11 @@ import (
 )
 
 var Render = render.New(render.Options{Extensions: []string{".html"}})
-var httpStatusRegistry StatusRegistry = NewStatusRegistry()
-var httpStatusRegistryManager = NewStatusRegistryManager()
-var httpStatusRegistryLock sync.RWMutex
 
 type ServerStatusModel struct {
 	Name   string
 	Status string
+	Reason string
 }
 
 type StatusOverviewModel struct {
@@ -29,96 +30,186 @@ type StatusOverviewModel struct {
 
 var upgrader = websocket.Upgrader{}
 
+// websocketGroup keeps track of all open websocket connections.
+var websocketGroup sync.WaitGroup
+
+const (
+	HTTP_SHUTDOWN_TIMEOUT   = 10 * time.Second
+	WEBSOCKET_PING_INTERVAL = 30 * time.Second
+	WEBSOCKET_PONG_TIMEOUT  = 2 * WEBSOCKET_PING_INTERVAL
+)
+
 // The HttpHandler sets up a HTTP endpoint to be used by 3rd parties to check if servers
-// are available or not. It is also notified of any change to the status registry in order
-// to notify live handlers.
-func HttpHandler(httpAddr string, doneGroup *sync.WaitGroup) {
-	statusUpdateChannel := make(chan StatusUpdate, 5)
-	go func() {
-		for {
-			update, ok := <-statusUpdateChannel
-			if !ok {
-				break
-			}
-			httpStatusRegistryLock.Lock()
-			httpStatusRegistry.SetStatusFromUpdate(update)
-			httpStatusRegistryLock.Unlock()
-			httpStatusRegistryManager.SetStatus(update)
-		}
-	}()
-	statusRegistryManager.NotifyChange(statusUpdateChannel)
-	defer statusRegistryManager.UnnotifyChange(statusUpdateChannel)
+// are available or not. All handlers read the global status registry directly.
+//
+// Once the context is done, websocket clients receive a close frame and the
+// server is shut down. Requests that are still in progress are given
+// HTTP_SHUTDOWN_TIMEOUT to finish.
+func HttpHandler(ctx context.Context, httpAddr string, api *ServerApi, doneGroup *sync.WaitGroup) {
+	defer doneGroup.Done()
 	log.Printf("Starting HTTP server on %s", httpAddr)
 	router := mux.NewRouter()
 	router.Path("/status/{server}/").HandlerFunc(httpServerStatusHandler)
 	router.Path("/overviewUpdates/").HandlerFunc(httpOverviewUpdatesHandler)
+	router.Path("/api/v1/events").Methods("GET").HandlerFunc(httpEventsHandler)
 	router.Path("/").HandlerFunc(httpFrontpageHandler)
-	http.ListenAndServe(httpAddr, router)
+	if api != nil {
+		api.Register(router)
+	}
+	server := &http.Server{
+		Addr:    httpAddr,
+		Handler: router,
+		// Long-running handlers like the websocket stop once the context
+		// is done.
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		log.Println("Shutting down HTTP server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), HTTP_SHUTDOWN_TIMEOUT)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("HTTP server didn't shut down cleanly: %s\n", err.Error())
+		}
+		// Hijacked websocket connections are not tracked by the server.
+		websocketGroup.Wait()
+	}()
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Printf("HTTP server failed: %s\n", err.Error())
+		return
+	}
+	<-shutdownDone
+}
+
+// An overviewMessage is sent through the websocket for every server when
+// the connection is established (type "snapshot") and for every status
+// update afterwards (type "update").
+type overviewMessage struct {
+	Type       string    `json:"type"`
+	ServerName string    `json:"server"`
+	Status     string    `json:"status"`
+	Reason     string    `json:"reason,omitempty"`
+	Tags       []string  `json:"tags,omitempty"`
+	Time       time.Time `json:"time"`
+}
+
+// newRequestServerFilter builds a serverFilter from the comma separated
+// "servers" and "tags" query parameters.
+func newRequestServerFilter(r *http.Request) serverFilter {
+	return newServerFilter(queryList(r, "servers"), queryList(r, "tags"))
+}
+
+func queryList(r *http.Request, name string) []string {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
 }
 
-// httpOverviewUpdatesHandler offers a websocket channel that notifies the
-// receiver of updates to any registered server.
+// httpOverviewUpdatesHandler offers a websocket channel that sends the
+// current status of all (matching) servers and notifies the receiver of
+// any updates afterwards. Clients that are too slow to receive all updates
+// are sent a new snapshot instead. Clients have to answer the periodic pings
+// in order to keep the connection open. The connection is closed with a
+// close frame once the request's context is done.
 func httpOverviewUpdatesHandler(w http.ResponseWriter, r *http.Request) {
+	// The connection has to be tracked before it is hijacked as the server
+	// only waits for regular requests during shutdown.
+	websocketGroup.Add(1)
+	defer websocketGroup.Done()
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		http.Error(w, "Failed to build Websocket connect", http.StatusInternalServerError)
 		return
 	}
-	updates := make(chan StatusUpdate, 5)
-	readyToExit := false
-	// Start a go-routine that drains the read messages and closes the connection
-	// if requested by the user.
+	defer conn.Close()
+	filter := newRequestServerFilter(r)
+	// Subscribe before taking the snapshot so that no update is missed.
+	sub, unsubscribe := statusRegistryManager.subscribe(func(event Event) bool {
+		return filter.Matches(event.ServerName, event.Tags)
+	}, 0)
+	defer unsubscribe()
+	var lastSent uint64
+	sendSnapshot := func() error {
+		statuses, id := statusRegistryManager.snapshot()
+		for _, status := range statuses {
+			if !filter.Matches(status.ServerName, status.Tags) {
+				continue
+			}
+			message := overviewMessage{"snapshot", status.ServerName, status.Status, status.Reason, status.Tags, status.Updated}
+			if err := conn.WriteJSON(message); err != nil {
+				return err
+			}
+		}
+		lastSent = id
+		return nil
+	}
+	if err := sendSnapshot(); err != nil {
+		return
+	}
+
+	// Start a go-routine that drains the read messages and notices if the
+	// client closes the connection or stops answering pings.
+	clientGone := make(chan struct{})
+	conn.SetReadDeadline(time.Now().Add(WEBSOCKET_PONG_TIMEOUT))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(WEBSOCKET_PONG_TIMEOUT))
+	})
 	go func() {
-		defer httpStatusRegistryManager.UnnotifyChange(updates)
+		defer close(clientGone)
 		for {
 			if _, _, err := conn.NextReader(); err != nil {
-				conn.Close()
-				readyToExit = true
-				break
+				return
 			}
 		}
 	}()
-	httpStatusRegistryManager.NotifyChange(updates)
-	defer httpStatusRegistryManager.UnnotifyChange(updates)
-overviewUpdatesLoop:
+	ticker := time.NewTicker(WEBSOCKET_PING_INTERVAL)
+	defer ticker.Stop()
 	for {
-		if readyToExit {
-			break overviewUpdatesLoop
-		}
-		var update StatusUpdate
-		var ok bool = true
-		var updateAvailable = false
-
 		select {
-		case update, ok = <-updates:
-			updateAvailable = true
-		default:
-			// We sleep and continue in order to keep the connection open
-			// indefinitely in case no update is ever received.
-			time.Sleep(500 * time.Millisecond)
-			continue overviewUpdatesLoop
-		}
-
-		if !ok {
-			break overviewUpdatesLoop
-		}
-		if updateAvailable {
-			if err = conn.WriteJSON(update); err != nil {
-				break overviewUpdatesLoop
+		case event := <-sub.channel:
+			// If the client is too slow, events are dropped from the
+			// subscription. A new snapshot brings it up to date again.
+			if sub.takeDropped() != 0 {
+				if err := sendSnapshot(); err != nil {
+					return
+				}
+			}
+			// The event might already be part of the snapshot.
+			if event.ID <= lastSent {
+				break
+			}
+			message := overviewMessage{"update", event.ServerName, event.Status, event.Reason, event.Tags, event.Time}
+			if err := conn.WriteJSON(message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second)); err != nil {
+				return
 			}
-			updateAvailable = false
+		case <-clientGone:
+			return
+		case <-r.Context().Done():
+			message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "statusd is shutting down")
+			conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+			return
 		}
 	}
-	conn.Close()
 }
 
+// httpFrontpageHandler renders the dashboard. It supports the same filters
+// as the websocket.
 func httpFrontpageHandler(w http.ResponseWriter, r *http.Request) {
 	model := StatusOverviewModel{}
-	httpStatusRegistryLock.RLock()
-	for name, _ := range httpStatusRegistry {
-		model.Servers = append(model.Servers, ServerStatusModel{Name: name, Status: httpStatusRegistry.GetStatus(name)})
+	filter := newRequestServerFilter(r)
+	for _, status := range statusRegistryManager.Snapshot() {
+		if filter.Matches(status.ServerName, status.Tags) {
+			model.Servers = append(model.Servers, ServerStatusModel{Name: status.ServerName, Status: status.Status, Reason: status.Reason})
+		}
 	}
-	httpStatusRegistryLock.RUnlock()
 	Render.HTML(w, 200, "index", model)
 }
 
@@ -129,22 +220,24 @@ func httpServerStatusHandler(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
-	httpStatusRegistryLock.RLock()
-	status := httpStatusRegistry.GetStatus(serverName)
-	httpStatusRegistryLock.RUnlock()
-	if status == "" {
+	status, found := statusRegistryManager.Get(serverName)
+	if !found {
 		http.NotFound(w, r)
 		return
 	}
 	if r.URL.Query().Get("mode") == "simple" {
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(status))
+		w.Write([]byte(status.Status))
 	} else {
 		Render.JSON(w, http.StatusOK, struct {
-			ServerName string `json:"server"`
-			Status     string `json:"status"`
+			ServerName string            `json:"server"`
+			Status     string            `json:"status"`
+			Tags       []string          `json:"tags,omitempty"`
+			Details    map[string]string `json:"details,omitempty"`
 		}{
 			serverName,
-			status})
+			status.Status,
+			status.Tags,
+			status.Details})
 	}
 }
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zerok/statusd/Godeps/_workspace/src/github.com/gorilla/mux"
+)
+
+func TestHttpServerStatusHandler(t *testing.T) {
+	statusRegistryManager.SetStatus(StatusUpdate{ServerName: "http-test", Status: STATUS_OFFLINE})
+	statusRegistryManager.SetDetails("http-test", map[string]string{"image": "nginx"})
+	defer statusRegistryManager.RemoveStatus("http-test")
+	router := mux.NewRouter()
+	router.Path("/status/{server}/").HandlerFunc(httpServerStatusHandler)
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest("GET", "/status/http-test/?mode=simple", nil))
+	if resp.Body.String() != STATUS_OFFLINE {
+		t.Errorf("Expected the status to be offline, got %q", resp.Body.String())
+	}
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest("GET", "/status/http-test/", nil))
+	if body := resp.Body.String(); !strings.Contains(body, `"status":"offline"`) || !strings.Contains(body, `"image":"nginx"`) {
+		t.Errorf("Unexpected response %s", body)
+	}
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest("GET", "/status/unknown/", nil))
+	if resp.Code != 404 {
+		t.Errorf("Expected unknown servers to return 404, got %d", resp.Code)
+	}
+}
+
+func TestHttpOverviewUpdatesHandler(t *testing.T) {
+	statusRegistryManager.SetServerInfo("ws-tagged", "", []string{"prod"})
+	statusRegistryManager.SetStatus(StatusUpdate{ServerName: "ws-tagged", Status: STATUS_ONLINE})
+	statusRegistryManager.SetStatus(StatusUpdate{ServerName: "ws-other", Status: STATUS_ONLINE})
+	defer statusRegistryManager.RemoveStatus("ws-tagged")
+	defer statusRegistryManager.RemoveStatus("ws-other")
+	server := httptest.NewServer(http.HandlerFunc(httpOverviewUpdatesHandler))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/?tags=prod", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var message overviewMessage
+	if err := conn.ReadJSON(&message); err != nil || message.Type != "snapshot" || message.ServerName != "ws-tagged" {
+		t.Fatalf("Expected a snapshot of ws-tagged, got %v (%v)", message, err)
+	}
+
+	statusRegistryManager.SetStatus(StatusUpdate{ServerName: "ws-other", Status: STATUS_OFFLINE})
+	statusRegistryManager.SetStatus(StatusUpdate{ServerName: "ws-tagged", Status: STATUS_OFFLINE, Reason: "Returned status 500"})
+	if err := conn.ReadJSON(&message); err != nil {
+		t.Fatal(err)
+	}
+	if message.Type != "update" || message.ServerName != "ws-tagged" || message.Reason != "Returned status 500" || message.Time.IsZero() {
+		t.Errorf("Unexpected message %v", message)
+	}
+}
+
+func TestHttpHandlerShutdown(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	doneGroup := &sync.WaitGroup{}
+	doneGroup.Add(1)
+	go HttpHandler(ctx, addr, nil, doneGroup)
+	done := make(chan struct{})
+	go func() {
+		doneGroup.Wait()
+		close(done)
+	}()
+
+	var conn *websocket.Conn
+	for deadline := time.Now().Add(5 * time.Second); conn == nil; {
+		if conn, _, err = websocket.DefaultDialer.Dial("ws://"+addr+"/overviewUpdates/", nil); err != nil {
+			if time.Now().After(deadline) {
+				cancel()
+				t.Fatal(err)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	defer conn.Close()
+
+	cancel()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		// The snapshot might be received before the close frame.
+		if _, _, err := conn.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+				t.Errorf("Expected a close frame, got %v", err)
+			}
+			break
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(HTTP_SHUTDOWN_TIMEOUT):
+		t.Error("HTTP server didn't shut down")
+	}
+}
+
+func TestHttpOverviewUpdatesHandlerOverflow(t *testing.T) {
+	statusRegistryManager.SetStatus(StatusUpdate{ServerName: "ws-slow", Status: STATUS_ONLINE})
+	defer statusRegistryManager.RemoveStatus("ws-slow")
+	server := httptest.NewServer(http.HandlerFunc(httpOverviewUpdatesHandler))
+	defer server.Close()
+	// A small receive buffer makes the handler block once the client stops
+	// reading.
+	dialer := websocket.Dialer{NetDial: func(network, addr string) (net.Conn, error) {
+		conn, err := net.Dial(network, addr)
+		if err == nil {
+			conn.(*net.TCPConn).SetReadBuffer(1024)
+		}
+		return conn, err
+	}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/?servers=ws-slow", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var message overviewMessage
+	if err := conn.ReadJSON(&message); err != nil || message.Type != "snapshot" {
+		t.Fatalf("Expected a snapshot, got %v (%v)", message, err)
+	}
+
+	// The client stalls while more events are published than fit into the
+	// subscription's buffer.
+	reason := strings.Repeat("x", 4096)
+	for i := 0; i < 200; i++ {
+		statusRegistryManager.SetStatus(StatusUpdate{ServerName: "ws-slow", Status: STATUS_ONLINE, Reason: reason})
+	}
+	statusRegistryManager.SetStatus(StatusUpdate{ServerName: "ws-slow", Status: STATUS_OFFLINE, Reason: "final"})
+	for message.Reason != "final" {
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("Expected the client to catch up with the final status: %v", err)
+		}
+	}
+	if message.Type != "snapshot" || message.Status != STATUS_OFFLINE {
+		t.Errorf("Expected a new snapshot with the final status, got %v", message)
+	}
+}
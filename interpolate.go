@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// fileReferencePattern matches lines whose (unquoted) value is a file
+// reference, optionally preceded by a key and/or the dash of a list item.
+var fileReferencePattern = regexp.MustCompile(`^(\s*(?:-\s+)?(?:[^\s'"#-][^:#'"]*:\s+)?)!file\s+("[^"]*"|'[^']*'|[^\s#]+)(\s*(?:#.*)?)$`)
+var blockScalarPattern = regexp.MustCompile(`(?:^\s*-|:)\s+[|>][-+0-9]*\s*(?:#.*)?$`)
+var envReferencePattern = regexp.MustCompile(`\$\$|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+// resolveFileReferences replaces every "!file /path/to/file" value within
+// the raw configuration data with the content of the referenced file.
+// Quoted values and the content of block scalars are left alone. Relative
+// paths are resolved against baseDir. A single trailing newline is removed
+// from the content.
+func (v *configurationValidator) resolveFileReferences(rawData []byte, baseDir string) []byte {
+	var result bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(rawData))
+	lineNo := 0
+	// blockIndent is the indentation of the line that started the current
+	// block scalar or -1 outside of block scalars.
+	blockIndent := -1
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Text()
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if blockIndent != -1 && (indent > blockIndent || strings.TrimSpace(line) == "") {
+			result.WriteString(line)
+			result.WriteByte('\n')
+			continue
+		}
+		blockIndent = -1
+		if blockScalarPattern.MatchString(line) {
+			blockIndent = indent
+		} else if match := fileReferencePattern.FindStringSubmatch(line); match != nil {
+			path := resolvePaths([]string{strings.Trim(match[2], `"'`)}, baseDir)[0]
+			value := `""`
+			if content, err := ioutil.ReadFile(path); err != nil {
+				v.addLineError(lineNo, "cannot read referenced file: %s", err.Error())
+			} else {
+				// Escape dollar signs so that the content is not
+				// interpolated any further.
+				quoted, _ := json.Marshal(strings.Replace(strings.TrimSuffix(string(content), "\n"), "$", "$$", -1))
+				value = string(quoted)
+			}
+			line = match[1] + value + match[3]
+		}
+		result.WriteString(line)
+		result.WriteByte('\n')
+	}
+	return result.Bytes()
+}
+
+// interpolate replaces every ${VAR} reference within the string values
+// below the given value with the value of the respective environment
+// variable. ${VAR:-default} can be used to provide a default value and $$
+// results in a literal dollar sign. Every reference to a variable that isn't
+// set (and has no default) is reported as error.
+func (v *configurationValidator) interpolate(path string, value reflect.Value) {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if !value.IsNil() {
+			v.interpolate(path, value.Elem())
+		}
+	case reflect.String:
+		result, missing := interpolateString(value.String(), os.LookupEnv)
+		for _, name := range missing {
+			v.addError(path, "environment variable %s referenced by %s is not set", name, path)
+		}
+		value.SetString(result)
+	case reflect.Struct:
+		fields := yamlFields(value.Type())
+		for name, field := range fields {
+			v.interpolate(joinPath(path, name), value.FieldByIndex(field.Index))
+		}
+	case reflect.Map:
+		for _, key := range value.MapKeys() {
+			// Map values aren't addressable so we have to work on a copy.
+			item := reflect.New(value.Type().Elem()).Elem()
+			item.Set(value.MapIndex(key))
+			v.interpolate(joinPath(path, fmt.Sprint(key.Interface())), item)
+			value.SetMapIndex(key, item)
+		}
+	case reflect.Slice:
+		for idx := 0; idx < value.Len(); idx++ {
+			v.interpolate(joinPath(path, fmt.Sprint(idx)), value.Index(idx))
+		}
+	}
+}
+
+// interpolateString replaces all environment variable references within
+// value and returns the names of all referenced variables that aren't set.
+func interpolateString(value string, lookup func(string) (string, bool)) (string, []string) {
+	var missing []string
+	result := envReferencePattern.ReplaceAllStringFunc(value, func(reference string) string {
+		if reference == "$$" {
+			return "$"
+		}
+		match := envReferencePattern.FindStringSubmatch(reference)
+		if envValue, found := lookup(match[1]); found {
+			return envValue
+		}
+		if match[2] != "" {
+			return match[3]
+		}
+		missing = append(missing, match[1])
+		return ""
+	})
+	return result, missing
+}
@@ -1,200 +1,100 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
 	"flag"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"time"
-
-	"github.com/zerok/statusd/Godeps/_workspace/src/gopkg.in/v2/yaml"
-)
-
-const (
-	DEFAULT_TIMEOUT = 30
-	DEFAULT_DELAY   = 30
-	STATUS_OFFLINE  = "offline"
-	STATUS_ONLINE   = "online"
 )
 
-type ServerConfiguration struct {
-	IsAliveUrl string `yaml:"isAliveUrl"`
-	Timeout    int    `yaml:"timeout"`
-	Delay      int    `yaml:"delay"`
-}
+var statusRegistryManager *StatusRegistryManager = NewStatusRegistryManager()
 
-type HttpConfiguration struct {
-	HostAddr string `yaml:"addr"`
-}
-
-type SlackConfiguration struct {
-	Token            string              `yaml:"token"`
-	Team             string              `yaml:"team"`
-	NotifiedChannels map[string][]string `yaml:"channels"`
-}
-
-type Configuration struct {
-	Servers map[string]ServerConfiguration `yaml:"servers"`
-	Slack   SlackConfiguration             `yaml:"slack"`
-	Http    HttpConfiguration              `yaml:"http"`
-}
-
-var statusRegistryManager StatusRegistryManager = NewStatusRegistryManager()
-
-// NewConfiguration parses YAML data provided through a Reader
-// into our configuration object. If any error occurs, no
-// Configuration will be returned and an error is generated.
-func NewConfiguration(r io.Reader) (*Configuration, error) {
-	result := &Configuration{}
-	rawData, err := ioutil.ReadAll(r)
-	if err != nil {
-		return nil, err
-	}
-	err = yaml.Unmarshal(rawData, result)
-	if err != nil {
-		return nil, err
-	}
-	return result, nil
-}
-
-// NewConfigurationFromFile creates a new Configuration struct from
-// the file behind the given path.
-func NewConfigurationFromFile(filepath string) (*Configuration, error) {
-	file, err := os.Open(filepath)
-	if err != nil {
-		return nil, err
-	}
-	defer file.Close()
-	return NewConfiguration(file)
-}
+const NOTIFICATION_FLUSH_TIMEOUT = 10 * time.Second
 
 // The StatusHandler updates the global server status mapping and triggers notifications
-// if a server's status has changed.
-func StatusHandler(config Configuration, statusUpdateChannel <-chan StatusUpdate, exitChannel chan struct{}, doneGroup *sync.WaitGroup) {
-	notificationChannel := make(chan StatusUpdate, 10)
-	notificationDoneGroup := sync.WaitGroup{}
-	var notifySlack bool = (len(config.Slack.NotifiedChannels) != 0)
-	if notifySlack {
-		notificationDoneGroup.Add(1)
-		go SlackNotifier(config, notificationChannel, &notificationDoneGroup)
+// if a server's status has changed. Once the context is done, all pending
+// updates are processed and the notifiers get NOTIFICATION_FLUSH_TIMEOUT to
+// send out what is left.
+func StatusHandler(ctx context.Context, notifications *NotificationDispatcher, statusUpdateChannel <-chan StatusUpdate, doneGroup *sync.WaitGroup) {
+	defer doneGroup.Done()
+	notifications.Start()
+	handleUpdate := func(status StatusUpdate) {
+		event := statusRegistryManager.SetStatus(status)
+		log.Println(status)
+		if notifications.Len() == 0 {
+			return
+		}
+		// If this was the first time the server got a status, don't send out a notification to avoid
+		// noise during restarts.
+		quiet := true
+		if event.PreviousStatus == "" {
+			log.Println("Skipping first status from entering the notification chain")
+		} else if status.Silent {
+			log.Printf("Skipping notification for %s outside of its active hours\n", status.ServerName)
+		} else {
+			quiet = false
+			notifications.Dispatch(event)
+		}
+		// Incidents still have to be resolved once a server is back online.
+		// Servers that are already offline on startup open their incident
+		// again as it might have expired while statusd was stopped.
+		if quiet && event.Status == STATUS_ONLINE {
+			notifications.DispatchQuiet(event)
+		} else if quiet && event.Status == STATUS_OFFLINE && !status.Silent {
+			notifications.DispatchQuiet(event)
+		}
 	}
 loop:
 	for {
 		select {
 		case status := <-statusUpdateChannel:
-			previousStatus := statusRegistryManager.GetStatus(status.ServerName)
-			statusRegistryManager.SetStatus(status)
-			log.Println(status)
-			if notifySlack {
-				// If this was the first time the server got a status, don't send out a notification to avoid
-				// noise during restarts.
-				if previousStatus != "" {
-					notificationChannel <- status
-				} else {
-					log.Println("Skipping first status from entering the notification chain")
-				}
-			}
-			break
-		case <-exitChannel:
+			handleUpdate(status)
+		case <-ctx.Done():
 			break loop
 		}
 	}
-	close(notificationChannel)
-	log.Println("Waiting for notification handlers to shut down.")
-	notificationDoneGroup.Wait()
-	doneGroup.Done()
-}
-
-// ServerHandler is responsible for checking a single server periodically and
-// reporting any status changes through the statusUpdateChannel.
-func ServerHandler(serverName string, serverConfig ServerConfiguration, statusUpdateChannel chan<- StatusUpdate, exitChannel chan struct{}, doneGroup *sync.WaitGroup) {
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-	}
-	client := http.Client{Transport: tr}
-	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		return fmt.Errorf("Received a redirection as response")
-	}
-	previousStatus := ""
-	newStatus := ""
-	timeout := serverConfig.Timeout
-	delay := serverConfig.Delay
-	if timeout == 0 {
-		timeout = DEFAULT_TIMEOUT
-	}
-	if delay == 0 {
-		delay = DEFAULT_DELAY
-	}
-	finalTimeout, err := time.ParseDuration(fmt.Sprintf("%ds", timeout))
-	if err != nil {
-		finalTimeout = DEFAULT_TIMEOUT * time.Second
-	}
-	finalDelay, err := time.ParseDuration(fmt.Sprintf("%ds", delay))
-	if err != nil {
-		finalDelay = DEFAULT_DELAY * time.Second
-	}
-	client.Timeout = finalTimeout
-	log.Printf("Processing server %v with a timeout of %vs\n", serverName, finalTimeout.Seconds())
-	var nextPlannedCheck time.Time
-loop:
-	for {
+	// Updates that were sent before the workers exited are still relevant.
+	for pending := true; pending; {
 		select {
-		case <-exitChannel:
-			log.Printf("Handler for %s received exit signal\n", serverName)
-			break loop
+		case status := <-statusUpdateChannel:
+			handleUpdate(status)
 		default:
+			pending = false
 		}
+	}
+	log.Println("Waiting for notification handlers to shut down.")
+	notifications.Close(NOTIFICATION_FLUSH_TIMEOUT)
+}
 
-		if time.Now().Before(nextPlannedCheck) {
-			time.Sleep(time.Second)
-			continue
-		}
-
-		startTime := time.Now()
-		resp, err := client.Get(serverConfig.IsAliveUrl)
-		duration := time.Now().Sub(startTime)
-		if err != nil {
-			log.Printf(err.Error())
-			newStatus = STATUS_OFFLINE
-		} else {
-			if resp.StatusCode != 200 {
-				log.Printf("Returned status %v", resp.StatusCode)
-				newStatus = STATUS_OFFLINE
-
-			} else {
-				newStatus = STATUS_ONLINE
-			}
-			resp.Body.Close()
-		}
-		if newStatus == STATUS_ONLINE {
-			log.Printf("%s is online\n", serverName)
-		} else {
-			log.Printf("%s is offline\n", serverName)
-		}
-		if newStatus != previousStatus {
-			statusUpdateChannel <- StatusUpdate{ServerName: serverName, Status: newStatus, Duration: duration}
-		}
-		previousStatus = newStatus
-
-		// Check the server periodically
-		if newStatus == STATUS_OFFLINE {
-			nextPlannedCheck = time.Now().Add(finalDelay * 2)
-		} else {
-			nextPlannedCheck = time.Now().Add(finalDelay)
-		}
+// validateCommand implements the "validate" subcommand which checks a
+// configuration file without starting any workers.
+func validateCommand(args []string) int {
+	var configPath string
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.StringVar(&configPath, "config", "", "Path to a configuration file")
+	flags.Parse(args)
+	if configPath == "" {
+		fmt.Fprintln(os.Stderr, "Please specify a configuration file using the -config flag")
+		return 2
+	}
+	if _, err := NewConfigurationFromFile(configPath); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", configPath, err.Error())
+		return 1
 	}
-	log.Printf("Shutting down %s worker\n", serverName)
-	doneGroup.Done()
+	fmt.Printf("%s: OK\n", configPath)
+	return 0
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validateCommand(os.Args[2:]))
+	}
+
 	var configPath string
 	// First we have to determine what servers should be checked and how. For that we
 	// parse our configuration file.
@@ -208,49 +108,98 @@ func main() {
 		log.Fatalln(err)
 	}
 
-	if config.Servers == nil || len(config.Servers) == 0 {
+	var state *ServerState
+	if config.Api.StateFile != "" {
+		state, err = LoadServerState(config.Api.StateFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	if len(config.Servers) == 0 && (state == nil || len(state.Servers) == 0) && config.Api.Token == "" && len(config.Discovery.Files) == 0 && config.Discovery.Docker == nil {
 		log.Fatalln("No servers configured")
 	}
 
-	doneGroup := sync.WaitGroup{}
-	exitChannel := make(chan struct{}, len(config.Servers))
+	// Every part of statusd has its own context so that they can be shut
+	// down in order: First the HTTP server so that no more changes are made
+	// through the API, then all workers, and finally the status handler so
+	// that the last notifications can still be sent.
+	httpCtx, stopHttp := context.WithCancel(context.Background())
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	statusCtx, stopStatus := context.WithCancel(context.Background())
+	httpDoneGroup := sync.WaitGroup{}
+	workerDoneGroup := sync.WaitGroup{}
+	statusDoneGroup := sync.WaitGroup{}
 	statusUpdateChannel := make(chan StatusUpdate, len(config.Servers))
-	signalChannel := make(chan os.Signal)
+	signalChannel := make(chan os.Signal, 1)
+	signal.Notify(signalChannel, syscall.SIGINT, syscall.SIGTERM)
+	pool := NewServerPool(workerCtx, config, statusUpdateChannel, &workerDoneGroup)
 
-	doneGroup.Add(1)
-	go StatusHandler(*config, statusUpdateChannel, exitChannel, &doneGroup)
+	notifications, err := NewNotificationDispatcher(config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	statusDoneGroup.Add(1)
+	go StatusHandler(statusCtx, notifications, statusUpdateChannel, &statusDoneGroup)
 
-	// For every server we create a seperate go-routine that checks the server periodically
+	// Every server is checked periodically by the pool's scheduler
 	for serverName, serverConfig := range config.Servers {
-		doneGroup.Add(1)
-		go ServerHandler(serverName, serverConfig, statusUpdateChannel, exitChannel, &doneGroup)
+		pool.Add(serverName, SOURCE_CONFIG, serverConfig)
+	}
+	if state != nil {
+		state.Restore(pool)
+	}
+
+	for idx, discoveryConfig := range config.Discovery.Files {
+		discoverer, err := NewFileDiscoverer(discoveryConfig)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		interval := time.Duration(discoveryConfig.RefreshInterval)
+		if interval == 0 {
+			interval = DEFAULT_DISCOVERY_REFRESH_INTERVAL
+		}
+		workerDoneGroup.Add(1)
+		go DiscoveryHandler(workerCtx, fmt.Sprintf("file discovery %d", idx), discoverer, interval, pool, &workerDoneGroup)
+	}
+	if config.Discovery.Docker != nil {
+		interval := time.Duration(config.Discovery.Docker.RefreshInterval)
+		if interval == 0 {
+			interval = DEFAULT_DISCOVERY_REFRESH_INTERVAL
+		}
+		workerDoneGroup.Add(1)
+		go DiscoveryHandler(workerCtx, "docker discovery", NewDockerDiscoverer(*config.Discovery.Docker), interval, pool, &workerDoneGroup)
+	}
+
+	var api *ServerApi
+	if config.Api.Token != "" {
+		api = NewServerApi(pool, config.Api)
 	}
 
 	if config.Http.HostAddr != "" {
-		// Can't add a waitgroup handler for the HTTP server just yet. Perhaps in Go 1.4 ;)
-		go HttpHandler(config.Http.HostAddr, &doneGroup)
+		httpDoneGroup.Add(1)
+		go HttpHandler(httpCtx, config.Http.HostAddr, api, &httpDoneGroup)
 	} else {
 		log.Println("No HTTP configuration present. Not starting HTTP server.")
+		if api != nil {
+			log.Println("The server API requires the HTTP server and is therefore disabled.")
+		}
 	}
 
+	sign := <-signalChannel
+	log.Printf("Received %v. Shutting down", sign)
 	go func() {
-		for {
-			sign := <-signalChannel
-			statusRegistryManager.ShowDown()
-			log.Printf("Received %v. Shutting down workers", sign)
-			for _ = range config.Servers {
-				exitChannel <- struct{}{}
-			}
-			// An additional notification for the status handler and the web interface
-			exitChannel <- struct{}{}
-			exitChannel <- struct{}{}
-		}
+		sign := <-signalChannel
+		log.Fatalf("Received %v again. Exiting immediately", sign)
 	}()
-
-	signal.Notify(signalChannel, syscall.SIGINT, syscall.SIGTERM)
-
-	log.Println("Waiting for threads to exit.")
-	doneGroup.Wait()
-	close(exitChannel)
-	close(statusUpdateChannel)
+	stopHttp()
+	httpDoneGroup.Wait()
+	log.Println("Waiting for workers to exit.")
+	stopWorkers()
+	workerDoneGroup.Wait()
+	if api != nil {
+		api.persist()
+	}
+	stopStatus()
+	statusDoneGroup.Wait()
 }
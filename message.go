@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// A Message describes a status change independently of the notifier that
+// sends it.
+type Message struct {
+	ServerName     string
+	Status         string
+	PreviousStatus string
+	Reason         string
+	// Duration is how long the check took.
+	Duration time.Duration
+	// Downtime is set if the server is back online after being offline.
+	Downtime time.Duration
+	Url      string
+	Tags     []string
+	Time     time.Time
+	// DashboardUrl links to the server on statusd's dashboard if a public
+	// URL is configured.
+	DashboardUrl string
+}
+
+// newMessage creates the message for an event. publicUrl is the address
+// under which statusd's dashboard can be reached (if any).
+func newMessage(event Event, publicUrl string) Message {
+	message := Message{
+		ServerName:     event.ServerName,
+		Status:         event.Status,
+		PreviousStatus: event.PreviousStatus,
+		Reason:         event.Reason,
+		Duration:       event.Duration,
+		Url:            event.Url,
+		Tags:           event.Tags,
+		Time:           event.Time,
+	}
+	if event.Status == STATUS_ONLINE && event.PreviousStatus == STATUS_OFFLINE {
+		message.Downtime = event.Elapsed
+	}
+	if publicUrl != "" {
+		message.DashboardUrl = strings.TrimSuffix(publicUrl, "/") + "/?servers=" + url.QueryEscape(event.ServerName)
+	}
+	return message
+}
+
+// Online reports whether the message is about a server being online.
+func (m Message) Online() bool {
+	return m.Status == STATUS_ONLINE
+}
+
+// Title returns a short summary like "web is now offline".
+func (m Message) Title() string {
+	return fmt.Sprintf("%s is now %s", m.ServerName, m.Status)
+}
+
+// Text returns a single line describing the status change.
+func (m Message) Text() string {
+	text := fmt.Sprintf("%s (check time: %v)", m.Title(), m.Duration)
+	if m.Reason != "" {
+		text += ": " + m.Reason
+	}
+	if m.Downtime != 0 {
+		text += fmt.Sprintf(" after being offline for %v", m.Downtime)
+	}
+	return text
+}
+
+// Fields returns additional information about the status change as pairs
+// of a label and a value. Empty values are skipped.
+func (m Message) Fields() [][2]string {
+	var fields [][2]string
+	add := func(label, value string) {
+		if value != "" {
+			fields = append(fields, [2]string{label, value})
+		}
+	}
+	add("Reason", m.Reason)
+	if m.Duration != 0 {
+		add("Check time", m.Duration.String())
+	}
+	if m.Downtime != 0 {
+		add("Offline for", m.Downtime.Round(time.Second).String())
+	}
+	add("URL", m.Url)
+	add("Tags", strings.Join(m.Tags, ", "))
+	if !m.Time.IsZero() {
+		add("Time", m.Time.Format(time.RFC1123))
+	}
+	return fields
+}
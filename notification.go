@@ -1,87 +1,211 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"net/http"
 	"net/url"
-	"sync"
+	"strings"
 )
 
+const (
+	DEFAULT_SLACK_USERNAME     = "StatusD"
+	DEFAULT_SLACK_ONLINE_ICON  = ":white_check_mark:"
+	DEFAULT_SLACK_OFFLINE_ICON = ":exclamation:"
+	SLACK_COLOR_ONLINE         = "#2eb886"
+	SLACK_COLOR_OFFLINE        = "#d50200"
+)
+
+// slackPostMessageUrl is the Web API endpoint used with a bot token. It can
+// be replaced in tests.
+var slackPostMessageUrl = "https://slack.com/api/chat.postMessage"
+
 type SlackPayload struct {
-	Text      string `json:"text"`
-	Channel   string `json:"channel"`
-	Username  string `json:"username"`
-	IconEmoji string `json:"icon_emoji"`
+	Text        string            `json:"text"`
+	Channel     string            `json:"channel,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	IconEmoji   string            `json:"icon_emoji,omitempty"`
+	Attachments []SlackAttachment `json:"attachments,omitempty"`
+}
+
+// A SlackAttachment is only used to show a colored bar next to the
+// message's blocks.
+type SlackAttachment struct {
+	Color  string       `json:"color"`
+	Blocks []SlackBlock `json:"blocks"`
+}
+
+type SlackBlock struct {
+	Type     string       `json:"type"`
+	Text     *SlackText   `json:"text,omitempty"`
+	Fields   []*SlackText `json:"fields,omitempty"`
+	Elements []*SlackText `json:"elements,omitempty"`
+}
+
+type SlackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
 }
 
-func SlackNotifier(config Configuration, updateChannel chan StatusUpdate, doneGroup *sync.WaitGroup) {
-	if len(config.Slack.NotifiedChannels) != 0 {
-		// Now we have something to handle
-	loop:
-		for {
-			status, more := <-updateChannel
-			if !more {
-				break loop
-			}
-			log.Printf("Notifying slack: %s\n", status)
-			if err := notifySlack(status, config.Slack); err != nil {
-				log.Printf("Slack notification failed: %s", err.Error())
-			}
+// The SlackNotifier posts status changes to Slack channels either through
+// an incoming webhook or the chat.postMessage Web API.
+type SlackNotifier struct {
+	config SlackNotifierConfiguration
+	// channelsByServer is used instead of the configured channels by
+	// notifiers created from the top-level slack block, which configures
+	// channels per server.
+	channelsByServer map[string][]string
+}
+
+func NewSlackNotifier(config SlackNotifierConfiguration) (*SlackNotifier, error) {
+	modes := 0
+	for _, configured := range []bool{config.WebhookUrl != "", config.BotToken != "", config.Team != "" || config.Token != ""} {
+		if configured {
+			modes++
 		}
-	} else {
-		log.Println("No slack configuration found.")
 	}
-	doneGroup.Done()
+	if modes != 1 {
+		return nil, fmt.Errorf("slack notifiers require either a webhookUrl, a botToken or a team and token")
+	}
+	if config.WebhookUrl != "" {
+		if parsed, err := url.Parse(config.WebhookUrl); err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return nil, fmt.Errorf("webhookUrl %q is not an absolute HTTPS URL", config.WebhookUrl)
+		}
+	} else if config.BotToken == "" && (config.Team == "" || config.Token == "") {
+		return nil, fmt.Errorf("slack notifiers require both a team and a token")
+	}
+	if config.WebhookUrl == "" && len(config.Channels) == 0 {
+		return nil, fmt.Errorf("slack notifiers require at least one channel")
+	}
+	return &SlackNotifier{config: config}, nil
+}
+
+// newLegacySlackNotifier creates a notifier for the top-level slack block.
+func newLegacySlackNotifier(config SlackConfiguration) *SlackNotifier {
+	return &SlackNotifier{
+		config:           SlackNotifierConfiguration{Team: config.Team, Token: config.Token},
+		channelsByServer: config.NotifiedChannels,
+	}
 }
 
-func notifySlack(status StatusUpdate, cfg SlackConfiguration) error {
-	channels, ok := cfg.NotifiedChannels[status.ServerName]
-	url := generateSlackUrl(cfg)
-	if !ok || len(channels) == 0 {
-		return nil
+func (n *SlackNotifier) Notify(ctx context.Context, message Message) error {
+	channels := n.config.Channels
+	if n.channelsByServer != nil {
+		channels = n.channelsByServer[message.ServerName]
+	}
+	// Incoming webhooks post to their own channel.
+	if n.config.WebhookUrl != "" && len(channels) == 0 {
+		channels = []string{""}
 	}
 	for _, channel := range channels {
 		log.Printf("Notifying channel %s\n", channel)
-		payload, err := buildSlackPayload(status, channel, cfg)
-		if err != nil {
-			return err
+		payload := buildSlackPayload(message, channel, n.config)
+		var err error
+		switch {
+		case n.config.WebhookUrl != "":
+			_, err = postJSON(ctx, n.config.WebhookUrl, nil, payload)
+		case n.config.BotToken != "":
+			err = n.postMessage(ctx, payload)
+		default:
+			err = n.postLegacy(ctx, payload)
 		}
-		resp, err := http.PostForm(url, payload)
 		if err != nil {
-			return err
-		}
-		if resp.StatusCode != 200 {
-			body, bodyError := ioutil.ReadAll(resp.Body)
-			if bodyError == nil {
-				log.Printf("BODY: %s\n", string(body))
-			}
-			return fmt.Errorf("Slack notification failed: %d %s", resp.StatusCode, resp.Status)
+			return fmt.Errorf("Slack notification failed: %s", err.Error())
 		}
 	}
 	return nil
 }
 
-func generateSlackUrl(cfg SlackConfiguration) string {
-	return fmt.Sprintf("https://%s.slack.com/services/hooks/incoming-webhook?token=%s", cfg.Team, cfg.Token)
+// postMessage sends the payload through the Web API, which reports errors
+// within the response body.
+func (n *SlackNotifier) postMessage(ctx context.Context, payload SlackPayload) error {
+	body, err := postJSON(ctx, slackPostMessageUrl, map[string]string{"Authorization": "Bearer " + n.config.BotToken}, payload)
+	if err != nil {
+		return err
+	}
+	var response struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return err
+	}
+	if !response.Ok {
+		return fmt.Errorf("chat.postMessage returned %s", response.Error)
+	}
+	return nil
 }
 
-func buildSlackPayload(status StatusUpdate, channel string, cfg SlackConfiguration) (url.Values, error) {
-	result := make(url.Values)
-	payload := SlackPayload{Text: fmt.Sprintf("%s is now *%s* (check time: %v)", status.ServerName, status.Status, status.Duration), Channel: channel}
-	// TODO: Make slack name and icons configurable
-	if status.Status == "offline" {
-		payload.IconEmoji = ":exclamation:"
-	} else {
-		payload.IconEmoji = ":white_check_mark:"
-	}
-	payload.Username = "StatusD"
+// postLegacy sends the payload as form data to the deprecated webhook
+// integration.
+func (n *SlackNotifier) postLegacy(ctx context.Context, payload SlackPayload) error {
 	rawData, err := json.Marshal(payload)
 	if err != nil {
-		return result, err
+		return err
+	}
+	form := url.Values{"payload": {string(rawData)}}
+	_, err = post(ctx, generateSlackUrl(n.config.Team, n.config.Token), "application/x-www-form-urlencoded", nil, strings.NewReader(form.Encode()))
+	return err
+}
+
+func generateSlackUrl(team, token string) string {
+	return fmt.Sprintf("https://%s.slack.com/services/hooks/incoming-webhook?token=%s", team, token)
+}
+
+// buildSlackPayload renders the message using Block Kit. The plain text is
+// used by Slack for notifications and clients without Block Kit support.
+func buildSlackPayload(message Message, channel string, cfg SlackNotifierConfiguration) SlackPayload {
+	payload := SlackPayload{
+		Text:     message.Text(),
+		Channel:  channel,
+		Username: cfg.Username,
+	}
+	if payload.Username == "" {
+		payload.Username = DEFAULT_SLACK_USERNAME
+	}
+	color := SLACK_COLOR_ONLINE
+	payload.IconEmoji = firstNonEmpty(cfg.OnlineIcon, DEFAULT_SLACK_ONLINE_ICON)
+	if !message.Online() {
+		color = SLACK_COLOR_OFFLINE
+		payload.IconEmoji = firstNonEmpty(cfg.OfflineIcon, DEFAULT_SLACK_OFFLINE_ICON)
+	}
+	blocks := []SlackBlock{{
+		Type: "section",
+		Text: &SlackText{"mrkdwn", fmt.Sprintf("%s *%s* is now *%s*", payload.IconEmoji, slackEscape(message.ServerName), message.Status)},
+	}}
+	var fields []*SlackText
+	for _, field := range message.Fields() {
+		value := slackEscape(field[1])
+		if field[0] == "URL" {
+			value = fmt.Sprintf("<%s>", field[1])
+		}
+		fields = append(fields, &SlackText{"mrkdwn", fmt.Sprintf("*%s*\n%s", field[0], value)})
+	}
+	if len(fields) != 0 {
+		blocks = append(blocks, SlackBlock{Type: "section", Fields: fields})
+	}
+	if message.DashboardUrl != "" {
+		blocks = append(blocks, SlackBlock{
+			Type:     "context",
+			Elements: []*SlackText{{"mrkdwn", fmt.Sprintf("<%s|Open dashboard>", message.DashboardUrl)}},
+		})
+	}
+	payload.Attachments = []SlackAttachment{{Color: color, Blocks: blocks}}
+	return payload
+}
+
+// slackEscape escapes the characters that have a special meaning within
+// Slack's mrkdwn.
+func slackEscape(text string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
 	}
-	result.Add("payload", string(rawData))
-	return result, nil
+	return ""
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlackNotifierBotToken(t *testing.T) {
+	var payload SlackPayload
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&payload)
+		if payload.Channel == "#broken" {
+			w.Write([]byte(`{"ok": false, "error": "channel_not_found"}`))
+			return
+		}
+		w.Write([]byte(`{"ok": true}`))
+	}))
+	defer server.Close()
+	defer func(url string) { slackPostMessageUrl = url }(slackPostMessageUrl)
+	slackPostMessageUrl = server.URL
+
+	notifier, err := NewSlackNotifier(SlackNotifierConfiguration{BotToken: "xoxb-1", Channels: []string{"#ops"}, Username: "Monitor"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := Message{
+		ServerName:   "web",
+		Status:       STATUS_ONLINE,
+		Downtime:     5 * time.Minute,
+		Url:          "http://web.example.com/",
+		DashboardUrl: "https://status.example.com/?servers=web",
+	}
+	if err := notifier.Notify(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	if authorization != "Bearer xoxb-1" || payload.Channel != "#ops" || payload.Username != "Monitor" {
+		t.Errorf("Unexpected request %s %v", authorization, payload)
+	}
+	if len(payload.Attachments) != 1 || payload.Attachments[0].Color != SLACK_COLOR_ONLINE {
+		t.Fatalf("Expected a green attachment, got %v", payload.Attachments)
+	}
+	var texts []string
+	for _, block := range payload.Attachments[0].Blocks {
+		for _, text := range append(append(block.Fields, block.Elements...), block.Text) {
+			if text != nil {
+				texts = append(texts, text.Text)
+			}
+		}
+	}
+	for _, expected := range []string{"*Offline for*\n5m0s", "*URL*\n<http://web.example.com/>", "<https://status.example.com/?servers=web|Open dashboard>"} {
+		if !strings.Contains(strings.Join(texts, "|"), expected) {
+			t.Errorf("Expected blocks to contain %s, got %v", expected, texts)
+		}
+	}
+
+	notifier.config.Channels = []string{"#broken"}
+	if err := notifier.Notify(context.Background(), message); err == nil || !strings.Contains(err.Error(), "channel_not_found") {
+		t.Errorf("Expected the API error to be reported, got %v", err)
+	}
+}
+
+func TestSlackNotifierWebhook(t *testing.T) {
+	var body string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+	}))
+	defer server.Close()
+	defer func(client *http.Client) { notificationClient = client }(notificationClient)
+	notificationClient = server.Client()
+
+	notifier, err := NewSlackNotifier(SlackNotifierConfiguration{WebhookUrl: server.URL + "/services/T/B/X", OfflineIcon: ":fire:"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(context.Background(), Message{ServerName: "db", Status: STATUS_OFFLINE, Reason: "Returned status 500"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, `"icon_emoji":":fire:"`) || !strings.Contains(body, SLACK_COLOR_OFFLINE) || strings.Contains(body, `"channel"`) {
+		t.Errorf("Unexpected payload %s", body)
+	}
+}
+
+func TestNewSlackNotifierValidation(t *testing.T) {
+	for _, config := range []SlackNotifierConfiguration{
+		{},
+		{WebhookUrl: "http://hooks.slack.com/services/T/B/X"},
+		{BotToken: "xoxb-1"},
+		{BotToken: "xoxb-1", WebhookUrl: "https://hooks.slack.com/services/T/B/X"},
+		{Team: "team", Channels: []string{"#ops"}},
+	} {
+		if _, err := NewSlackNotifier(config); err == nil {
+			t.Errorf("Expected %v to be invalid", config)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	NOTIFIER_SLACK        = "slack"
+	NOTIFIER_WEBHOOK      = "webhook"
+	NOTIFIER_EMAIL        = "email"
+	NOTIFIER_PAGERDUTY    = "pagerduty"
+	NOTIFIER_ALERTMANAGER = "alertmanager"
+	NOTIFIER_TEAMS        = "teams"
+	NOTIFIER_DISCORD      = "discord"
+	NOTIFIER_MATTERMOST   = "mattermost"
+	NOTIFIER_TELEGRAM     = "telegram"
+	NOTIFIER_MATRIX       = "matrix"
+	NOTIFIER_EXEC         = "exec"
+)
+
+const (
+	NOTIFIER_QUEUE_SIZE  = 100
+	NOTIFICATION_TIMEOUT = 30 * time.Second
+)
+
+// A Notifier sends status changes to an external service.
+type Notifier interface {
+	Notify(ctx context.Context, message Message) error
+}
+
+// NewNotifier creates the notifier described by the given configuration.
+func NewNotifier(config NotifierConfiguration) (Notifier, error) {
+	switch config.Type {
+	case NOTIFIER_SLACK:
+		if config.Slack == nil {
+			return nil, fmt.Errorf("missing slack settings")
+		}
+		return NewSlackNotifier(*config.Slack)
+	case NOTIFIER_WEBHOOK:
+		if config.Webhook == nil {
+			return nil, fmt.Errorf("missing webhook settings")
+		}
+		return NewWebhookNotifier(*config.Webhook)
+	case NOTIFIER_EMAIL:
+		if config.Email == nil {
+			return nil, fmt.Errorf("missing email settings")
+		}
+		return NewEmailNotifier(*config.Email)
+	case NOTIFIER_PAGERDUTY:
+		if config.PagerDuty == nil {
+			return nil, fmt.Errorf("missing pagerduty settings")
+		}
+		return NewPagerDutyNotifier(*config.PagerDuty)
+	case NOTIFIER_ALERTMANAGER:
+		if config.Alertmanager == nil {
+			return nil, fmt.Errorf("missing alertmanager settings")
+		}
+		return NewAlertmanagerNotifier(*config.Alertmanager)
+	case NOTIFIER_TEAMS:
+		if config.Teams == nil {
+			return nil, fmt.Errorf("missing teams settings")
+		}
+		return NewTeamsNotifier(*config.Teams)
+	case NOTIFIER_DISCORD:
+		if config.Discord == nil {
+			return nil, fmt.Errorf("missing discord settings")
+		}
+		return NewDiscordNotifier(*config.Discord)
+	case NOTIFIER_MATTERMOST:
+		if config.Mattermost == nil {
+			return nil, fmt.Errorf("missing mattermost settings")
+		}
+		return NewMattermostNotifier(*config.Mattermost)
+	case NOTIFIER_TELEGRAM:
+		if config.Telegram == nil {
+			return nil, fmt.Errorf("missing telegram settings")
+		}
+		return NewTelegramNotifier(*config.Telegram)
+	case NOTIFIER_MATRIX:
+		if config.Matrix == nil {
+			return nil, fmt.Errorf("missing matrix settings")
+		}
+		return NewMatrixNotifier(*config.Matrix)
+	case NOTIFIER_EXEC:
+		if config.Exec == nil {
+			return nil, fmt.Errorf("missing exec settings")
+		}
+		return NewExecNotifier(*config.Exec)
+	}
+	return nil, fmt.Errorf("unknown notifier type %q", config.Type)
+}
+
+// A BatchNotifier receives all status changes that happen within its batch
+// window at once instead of one by one.
+type BatchNotifier interface {
+	Notifier
+	BatchWindow() time.Duration
+	NotifyBatch(ctx context.Context, messages []Message) error
+}
+
+// A PeriodicNotifier is called at a fixed interval in addition to every
+// status change, e.g. to re-send active alerts. Tick and Notify are never
+// called concurrently.
+type PeriodicNotifier interface {
+	Notifier
+	Interval() time.Duration
+	Tick(ctx context.Context) error
+}
+
+// A ResolvingNotifier closes the incidents it has opened once a server is
+// back online. Unlike other notifiers, it also receives the events that are
+// otherwise not sent: recoveries outside of a server's active hours and the
+// first status of a server after statusd has started, so that incidents are
+// kept in sync across restarts. Its Notify method calls Resolve for online
+// servers.
+type ResolvingNotifier interface {
+	Notifier
+	Resolve(ctx context.Context, message Message) error
+}
+
+// A notifierWorker delivers events to a single notifier. Every worker has
+// its own queue so that a slow notifier doesn't delay the others.
+type notifierWorker struct {
+	name     string
+	notifier Notifier
+	filter   serverFilter
+	queue    chan Message
+}
+
+// The NotificationDispatcher routes events to every notifier that is
+// interested in them.
+type NotificationDispatcher struct {
+	workers   []*notifierWorker
+	publicUrl string
+	doneGroup sync.WaitGroup
+	ctx       context.Context
+	cancel    context.CancelFunc
+}
+
+// NewNotificationDispatcher creates a dispatcher with all notifiers of the
+// given configuration including the top-level slack block.
+func NewNotificationDispatcher(config *Configuration) (*NotificationDispatcher, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	d := &NotificationDispatcher{ctx: ctx, cancel: cancel, publicUrl: config.Http.PublicUrl}
+	if len(config.Slack.NotifiedChannels) != 0 {
+		d.Add("slack", newLegacySlackNotifier(config.Slack), serverFilter{})
+	}
+	for _, notifierConfig := range config.Notifiers {
+		notifier, err := NewNotifier(notifierConfig)
+		if err != nil {
+			return nil, fmt.Errorf("Notifier %s: %s", notifierConfig.Name, err.Error())
+		}
+		d.Add(notifierConfig.Name, notifier, newServerFilter(notifierConfig.Servers, notifierConfig.Tags))
+	}
+	return d, nil
+}
+
+// Add registers a notifier that receives all events matching the filter.
+// It has to be called before Start.
+func (d *NotificationDispatcher) Add(name string, notifier Notifier, filter serverFilter) {
+	d.workers = append(d.workers, &notifierWorker{
+		name:     name,
+		notifier: notifier,
+		filter:   filter,
+		queue:    make(chan Message, NOTIFIER_QUEUE_SIZE),
+	})
+}
+
+// Len returns the number of notifiers.
+func (d *NotificationDispatcher) Len() int {
+	return len(d.workers)
+}
+
+// Start launches a go-routine per notifier.
+func (d *NotificationDispatcher) Start() {
+	for _, worker := range d.workers {
+		d.doneGroup.Add(1)
+		go worker.run(d.ctx, &d.doneGroup)
+	}
+}
+
+// Dispatch queues the event for every matching notifier. Events are
+// dropped for notifiers whose queue is full.
+func (d *NotificationDispatcher) Dispatch(event Event) {
+	d.dispatch(event, false)
+}
+
+// DispatchQuiet queues an event that isn't worth a notification for the
+// ResolvingNotifiers only.
+func (d *NotificationDispatcher) DispatchQuiet(event Event) {
+	d.dispatch(event, true)
+}
+
+func (d *NotificationDispatcher) dispatch(event Event, resolvingOnly bool) {
+	message := newMessage(event, d.publicUrl)
+	for _, worker := range d.workers {
+		if !worker.filter.Matches(event.ServerName, event.Tags) {
+			continue
+		}
+		if _, resolving := worker.notifier.(ResolvingNotifier); resolvingOnly && !resolving {
+			continue
+		}
+		select {
+		case worker.queue <- message:
+		default:
+			log.Printf("Dropping notification about %s for %s as its queue is full\n", event.ServerName, worker.name)
+		}
+	}
+}
+
+// Close waits up to the given timeout for all queued events to be sent.
+// Notifications that are still in progress afterwards are cancelled.
+func (d *NotificationDispatcher) Close(timeout time.Duration) {
+	for _, worker := range d.workers {
+		close(worker.queue)
+	}
+	flushed := make(chan struct{})
+	go func() {
+		d.doneGroup.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-time.After(timeout):
+		log.Println("Giving up on pending notifications.")
+	}
+	d.cancel()
+}
+
+func (w *notifierWorker) run(ctx context.Context, doneGroup *sync.WaitGroup) {
+	defer doneGroup.Done()
+	var ticks <-chan time.Time
+	periodic, isPeriodic := w.notifier.(PeriodicNotifier)
+	if isPeriodic {
+		ticker := time.NewTicker(periodic.Interval())
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+	for {
+		select {
+		case message, ok := <-w.queue:
+			if !ok {
+				return
+			}
+			w.notify(ctx, message)
+		case <-ticks:
+			tickCtx, cancel := context.WithTimeout(ctx, NOTIFICATION_TIMEOUT)
+			if err := periodic.Tick(tickCtx); err != nil {
+				log.Printf("Periodic notification through %s failed: %s\n", w.name, err.Error())
+			}
+			cancel()
+		}
+	}
+}
+
+func (w *notifierWorker) notify(ctx context.Context, message Message) {
+	messages := []Message{message}
+	batcher, batching := w.notifier.(BatchNotifier)
+	if batching {
+		messages = w.collect(messages, batcher.BatchWindow())
+	}
+	var err error
+	notifyCtx, cancel := context.WithTimeout(ctx, NOTIFICATION_TIMEOUT)
+	defer cancel()
+	if batching {
+		log.Printf("Notifying %s about %d status changes\n", w.name, len(messages))
+		err = batcher.NotifyBatch(notifyCtx, messages)
+	} else {
+		log.Printf("Notifying %s: %s\n", w.name, message.Text())
+		err = w.notifier.Notify(notifyCtx, message)
+	}
+	if err != nil {
+		log.Printf("Notification through %s failed: %s\n", w.name, err.Error())
+	}
+}
+
+// collect adds all messages that are queued within the given window to the
+// batch. The batch is complete right away once the queue is closed.
+func (w *notifierWorker) collect(messages []Message, window time.Duration) []Message {
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+	for {
+		select {
+		case message, ok := <-w.queue:
+			if !ok {
+				return messages
+			}
+			messages = append(messages, message)
+		case <-timer.C:
+			return messages
+		}
+	}
+}
+
+// notificationClient is shared by all notifiers that use HTTP. Timeouts
+// are controlled through the context of every notification.
+var notificationClient = &http.Client{}
+
+// post sends a request to the given URL and returns the response body. An
+// error is returned for any status code other than 2xx.
+func post(ctx context.Context, url, contentType string, headers map[string]string, body io.Reader) ([]byte, error) {
+	return send(ctx, "POST", url, contentType, headers, body)
+}
+
+// postJSON sends the payload encoded as JSON using post.
+func postJSON(ctx context.Context, url string, headers map[string]string, payload interface{}) ([]byte, error) {
+	return sendJSON(ctx, "POST", url, headers, payload)
+}
+
+// send works like post for any request method.
+func send(ctx context.Context, method, url, contentType string, headers map[string]string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := notificationClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	responseBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return responseBody, fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(responseBody)))
+	}
+	return responseBody, nil
+}
+
+// sendJSON sends the payload encoded as JSON using send.
+func sendJSON(ctx context.Context, method, url string, headers map[string]string, payload interface{}) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return send(ctx, method, url, "application/json", headers, bytes.NewReader(data))
+}
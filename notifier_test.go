@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingNotifier struct {
+	lock     sync.Mutex
+	messages []Message
+	block    chan struct{}
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, message Message) error {
+	if n.block != nil {
+		select {
+		case <-n.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	n.lock.Lock()
+	n.messages = append(n.messages, message)
+	n.lock.Unlock()
+	return nil
+}
+
+func (n *recordingNotifier) servers() []string {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	var result []string
+	for _, message := range n.messages {
+		result = append(result, message.ServerName)
+	}
+	return result
+}
+
+func TestNotificationDispatcherRouting(t *testing.T) {
+	all := &recordingNotifier{}
+	prod := &recordingNotifier{}
+	slow := &recordingNotifier{block: make(chan struct{})}
+	d, _ := NewNotificationDispatcher(&Configuration{})
+	d.Add("all", all, serverFilter{})
+	d.Add("prod", prod, newServerFilter(nil, []string{"prod"}))
+	d.Add("slow", slow, newServerFilter([]string{"db"}, nil))
+	d.Start()
+
+	d.Dispatch(Event{StatusUpdate: StatusUpdate{ServerName: "web"}, Tags: []string{"prod"}})
+	d.Dispatch(Event{StatusUpdate: StatusUpdate{ServerName: "db"}})
+	// The blocked notifier must not hold back the others.
+	deadline := time.Now().Add(time.Second)
+	for len(all.servers()) != 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	d.Close(50 * time.Millisecond)
+
+	if servers := all.servers(); strings.Join(servers, ",") != "web,db" {
+		t.Errorf("Expected all events, got %v", servers)
+	}
+	if servers := prod.servers(); strings.Join(servers, ",") != "web" {
+		t.Errorf("Expected only tagged servers, got %v", servers)
+	}
+	if servers := slow.servers(); len(servers) != 0 {
+		t.Errorf("Expected the blocked notification to be cancelled, got %v", servers)
+	}
+}
+
+func TestNotifierValidation(t *testing.T) {
+	_, err := NewConfiguration(strings.NewReader(`
+notifiers:
+    - name: ops
+      type: slack
+      slack:
+          team: example
+          token: abc
+    - name: ops
+      type: pager
+`))
+	expected := []string{
+		"line 3: notifier ops: slack notifiers require at least one channel",
+		"line 8: notifier ops is defined more than once",
+		`line 8: notifier ops: unknown notifier type "pager"`,
+	}
+	for _, message := range expected {
+		if err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected error %q, got %v", message, err)
+		}
+	}
+}
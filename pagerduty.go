@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	PAGERDUTY_SEVERITY_CRITICAL = "critical"
+	PAGERDUTY_SEVERITY_ERROR    = "error"
+	PAGERDUTY_SEVERITY_WARNING  = "warning"
+	PAGERDUTY_SEVERITY_INFO     = "info"
+)
+
+// pagerDutyEventsUrl is the endpoint of the Events API v2. It can be
+// replaced in tests.
+var pagerDutyEventsUrl = "https://events.pagerduty.com/v2/enqueue"
+
+type PagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *PagerDutyPayload `json:"payload,omitempty"`
+	Links       []PagerDutyLink   `json:"links,omitempty"`
+}
+
+type PagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     string            `json:"timestamp,omitempty"`
+	Component     string            `json:"component,omitempty"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+type PagerDutyLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+// The PagerDutyNotifier triggers an incident when a server goes offline and
+// resolves it once the server is back online. Both events share the same
+// dedup key, so PagerDuty closes incidents automatically.
+type PagerDutyNotifier struct {
+	config PagerDutyNotifierConfiguration
+}
+
+func NewPagerDutyNotifier(config PagerDutyNotifierConfiguration) (*PagerDutyNotifier, error) {
+	if config.RoutingKey == "" && len(config.RoutingKeys) == 0 {
+		return nil, fmt.Errorf("pagerduty notifiers require a routingKey")
+	}
+	for _, severity := range append([]string{config.Severity}, mapValues(config.Severities)...) {
+		switch severity {
+		case "", PAGERDUTY_SEVERITY_CRITICAL, PAGERDUTY_SEVERITY_ERROR, PAGERDUTY_SEVERITY_WARNING, PAGERDUTY_SEVERITY_INFO:
+		default:
+			return nil, fmt.Errorf("unknown severity %q", severity)
+		}
+	}
+	return &PagerDutyNotifier{config: config}, nil
+}
+
+func mapValues(values map[string]string) []string {
+	var result []string
+	for _, value := range values {
+		result = append(result, value)
+	}
+	return result
+}
+
+func (n *PagerDutyNotifier) Notify(ctx context.Context, message Message) error {
+	routingKey, err := n.routingKey(message.ServerName)
+	if err != nil {
+		return err
+	}
+	event := PagerDutyEvent{
+		RoutingKey: routingKey,
+		DedupKey:   pagerDutyDedupKey(message.ServerName),
+	}
+	switch message.Status {
+	case STATUS_ONLINE:
+		return n.Resolve(ctx, message)
+	case STATUS_OFFLINE:
+		event.EventAction = "trigger"
+		event.Payload = &PagerDutyPayload{
+			Summary:   message.Title(),
+			Source:    firstNonEmpty(message.Url, message.ServerName),
+			Severity:  n.severity(message),
+			Component: message.ServerName,
+		}
+		if !message.Time.IsZero() {
+			event.Payload.Timestamp = message.Time.Format(time.RFC3339)
+		}
+		if message.Reason != "" {
+			event.Payload.Summary += ": " + message.Reason
+		}
+		event.Payload.CustomDetails = make(map[string]string)
+		for _, field := range message.Fields() {
+			event.Payload.CustomDetails[field[0]] = field[1]
+		}
+		if message.DashboardUrl != "" {
+			event.Links = []PagerDutyLink{{message.DashboardUrl, "statusd dashboard"}}
+		}
+	default:
+		return nil
+	}
+	_, err = postJSON(ctx, pagerDutyEventsUrl, nil, event)
+	return err
+}
+
+// Resolve closes the server's incident. This is also done for servers
+// that are online after statusd has started, as their incident might have
+// been triggered before. Resolving an unknown incident has no effect.
+func (n *PagerDutyNotifier) Resolve(ctx context.Context, message Message) error {
+	routingKey, err := n.routingKey(message.ServerName)
+	if err != nil {
+		return err
+	}
+	_, err = postJSON(ctx, pagerDutyEventsUrl, nil, PagerDutyEvent{
+		RoutingKey:  routingKey,
+		EventAction: "resolve",
+		DedupKey:    pagerDutyDedupKey(message.ServerName),
+	})
+	return err
+}
+
+func (n *PagerDutyNotifier) routingKey(serverName string) (string, error) {
+	routingKey := firstNonEmpty(n.config.RoutingKeys[serverName], n.config.RoutingKey)
+	if routingKey == "" {
+		return "", fmt.Errorf("no routing key for %s", serverName)
+	}
+	return routingKey, nil
+}
+
+// severity looks up the severity by the server's name first and its tags
+// afterwards.
+func (n *PagerDutyNotifier) severity(message Message) string {
+	if severity, found := n.config.Severities[message.ServerName]; found {
+		return severity
+	}
+	for _, tag := range message.Tags {
+		if severity, found := n.config.Severities[tag]; found {
+			return severity
+		}
+	}
+	return firstNonEmpty(n.config.Severity, PAGERDUTY_SEVERITY_CRITICAL)
+}
+
+// pagerDutyDedupKey identifies the incident of a server. It doesn't change
+// across restarts so that incidents triggered before can still be resolved
+// (see Resolve).
+func pagerDutyDedupKey(serverName string) string {
+	return "statusd/" + serverName
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestPagerDutyNotifier(t *testing.T) {
+	var events []PagerDutyEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event PagerDutyEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		events = append(events, event)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"status": "success"}`))
+	}))
+	defer server.Close()
+	defer func(url string) { pagerDutyEventsUrl = url }(pagerDutyEventsUrl)
+	pagerDutyEventsUrl = server.URL
+
+	notifier, err := NewPagerDutyNotifier(PagerDutyNotifierConfiguration{
+		RoutingKey:  "default",
+		RoutingKeys: map[string]string{"checkout": "payments"},
+		Severity:    PAGERDUTY_SEVERITY_ERROR,
+		Severities:  map[string]string{"prod": PAGERDUTY_SEVERITY_CRITICAL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range []Message{
+		{ServerName: "checkout", Status: STATUS_OFFLINE, Reason: "timeout", Tags: []string{"prod"}},
+		{ServerName: "web", Status: STATUS_OFFLINE, Url: "http://web/"},
+		{ServerName: "checkout", Status: STATUS_ONLINE},
+	} {
+		if err := notifier.Notify(context.Background(), message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %v", events)
+	}
+	trigger := events[0]
+	if trigger.EventAction != "trigger" || trigger.RoutingKey != "payments" || trigger.Payload.Severity != PAGERDUTY_SEVERITY_CRITICAL {
+		t.Errorf("Unexpected trigger %v", trigger)
+	}
+	if trigger.Payload.Summary != "checkout is now offline: timeout" || trigger.Payload.CustomDetails["Reason"] != "timeout" {
+		t.Errorf("Unexpected payload %v", trigger.Payload)
+	}
+	if events[1].RoutingKey != "default" || events[1].Payload.Severity != PAGERDUTY_SEVERITY_ERROR || events[1].Payload.Source != "http://web/" {
+		t.Errorf("Unexpected trigger %v", events[1])
+	}
+	resolve := events[2]
+	if resolve.EventAction != "resolve" || resolve.DedupKey != trigger.DedupKey || resolve.RoutingKey != "payments" || resolve.Payload != nil {
+		t.Errorf("Expected the incident to be resolved, got %v", resolve)
+	}
+}
+
+func TestPagerDutyNotifierQuietRecoveries(t *testing.T) {
+	var lock sync.Mutex
+	var events []PagerDutyEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event PagerDutyEvent
+		json.NewDecoder(r.Body).Decode(&event)
+		lock.Lock()
+		events = append(events, event)
+		lock.Unlock()
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	defer func(url string) { pagerDutyEventsUrl = url }(pagerDutyEventsUrl)
+	pagerDutyEventsUrl = server.URL
+	notifier, _ := NewPagerDutyNotifier(PagerDutyNotifierConfiguration{RoutingKey: "default"})
+	d, _ := NewNotificationDispatcher(&Configuration{})
+	d.Add("pagerduty", notifier, serverFilter{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	doneGroup := &sync.WaitGroup{}
+	doneGroup.Add(1)
+	updates := make(chan StatusUpdate)
+	go StatusHandler(ctx, d, updates, doneGroup)
+	defer statusRegistryManager.RemoveStatus("pd-restarted")
+	defer statusRegistryManager.RemoveStatus("pd-silent")
+	defer statusRegistryManager.RemoveStatus("pd-inactive")
+	// An incident might have been triggered before statusd was restarted.
+	updates <- StatusUpdate{ServerName: "pd-restarted", Status: STATUS_ONLINE}
+	// A server that is offline on startup triggers its incident again.
+	updates <- StatusUpdate{ServerName: "pd-silent", Status: STATUS_OFFLINE}
+	// Unless it is outside of its active hours.
+	updates <- StatusUpdate{ServerName: "pd-inactive", Status: STATUS_OFFLINE, Silent: true}
+	updates <- StatusUpdate{ServerName: "pd-silent", Status: STATUS_ONLINE}
+	updates <- StatusUpdate{ServerName: "pd-silent", Status: STATUS_OFFLINE}
+	// The server recovers outside of its active hours.
+	updates <- StatusUpdate{ServerName: "pd-silent", Status: STATUS_ONLINE, Silent: true}
+	cancel()
+	doneGroup.Wait()
+
+	var actions []string
+	for _, event := range events {
+		actions = append(actions, event.DedupKey+" "+event.EventAction)
+	}
+	expected := "statusd/pd-restarted resolve,statusd/pd-silent trigger,statusd/pd-silent resolve,statusd/pd-silent trigger,statusd/pd-silent resolve"
+	if strings.Join(actions, ",") != expected {
+		t.Errorf("Expected %s, got %v", expected, actions)
+	}
+}
+
+func TestNewPagerDutyNotifierValidation(t *testing.T) {
+	for _, config := range []PagerDutyNotifierConfiguration{
+		{},
+		{RoutingKey: "key", Severity: "fatal"},
+		{RoutingKeys: map[string]string{"web": "key"}, Severities: map[string]string{"web": "low"}},
+	} {
+		if _, err := NewPagerDutyNotifier(config); err == nil {
+			t.Errorf("Expected %v to be invalid", config)
+		}
+	}
+}
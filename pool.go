@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	SOURCE_CONFIG = "config"
+	SOURCE_API    = "api"
+)
+
+// A serverWorker is either a check run by the scheduler or, for server
+// groups, a go-routine. Both are stopped by cancelling their context.
+type serverWorker struct {
+	config ServerConfiguration
+	source string
+	// members is the source of the servers discovered by a group.
+	members string
+	job     *ScheduledJob
+	cancel  context.CancelFunc
+}
+
+// The ServerPool keeps track of all the servers that are currently being
+// checked. Every server is checked through a job of the pool's scheduler,
+// which can be added and removed independently of the others while statusd
+// is running.
+type ServerPool struct {
+	ctx                 context.Context
+	lock                sync.RWMutex
+	workers             map[string]*serverWorker
+	config              *Configuration
+	scheduler           *Scheduler
+	statusUpdateChannel chan<- StatusUpdate
+	doneGroup           *sync.WaitGroup
+}
+
+// NewServerPool creates an empty pool and starts its scheduler. The given
+// configuration provides the scheduler settings and the defaults for every
+// server that is added later on. Once the context is done, all workers are
+// stopped (including in-flight checks) and doneGroup is notified once the
+// scheduler and all group handlers have exited. The pool keeps track of its
+// servers afterwards but doesn't check them anymore.
+func NewServerPool(ctx context.Context, config *Configuration, statusUpdateChannel chan<- StatusUpdate, doneGroup *sync.WaitGroup) *ServerPool {
+	scheduler := NewScheduler(config.Scheduler.MaxConcurrency, config.Scheduler.Jitter)
+	scheduler.Start(ctx, doneGroup)
+	return &ServerPool{
+		ctx:                 ctx,
+		workers:             make(map[string]*serverWorker),
+		config:              config,
+		scheduler:           scheduler,
+		statusUpdateChannel: statusUpdateChannel,
+		doneGroup:           doneGroup,
+	}
+}
+
+// Add starts checking a new server. The source identifies who is
+// responsible for the server (e.g. the configuration file or the API).
+// An error is returned if a server with the same name already exists.
+func (p *ServerPool) Add(name, source string, config ServerConfiguration) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, found := p.workers[name]; found {
+		return fmt.Errorf("Server %s already exists", name)
+	}
+	p.start(name, source, config)
+	return nil
+}
+
+// Set starts checking the given server or restarts its worker with the new
+// configuration if it is already known. Servers of other sources are not
+// changed.
+func (p *ServerPool) Set(name, source string, config ServerConfiguration) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if worker, found := p.workers[name]; found {
+		if worker.source != source {
+			return fmt.Errorf("Server %s is already defined by %s", name, worker.source)
+		}
+		p.shutdown(worker)
+	}
+	p.start(name, source, config)
+	return nil
+}
+
+// Remove stops the worker of the given server and forgets its status. It
+// returns false if no such server was known and fails for servers of other
+// sources.
+func (p *ServerPool) Remove(name, source string) (bool, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	worker, found := p.workers[name]
+	if !found {
+		return false, nil
+	}
+	if worker.source != source {
+		return true, fmt.Errorf("Server %s is already defined by %s", name, worker.source)
+	}
+	p.stop(name)
+	return true, nil
+}
+
+// Sync makes sure that exactly the given servers are checked for the given
+// source. Servers of that source that are not part of the given map are
+// removed, new ones are added and servers whose configuration has changed
+// are restarted. Servers owned by other sources are never touched. The
+// names of all added and removed servers are returned in sorted order.
+func (p *ServerPool) Sync(source string, servers map[string]ServerConfiguration) (added []string, removed []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.sync(source, servers)
+}
+
+// syncMembers works like Sync for the members of a server group. Nothing
+// is changed once the group handler's context is done, as the members are
+// removed together with the group (see shutdown). Checking the context
+// while holding the lock makes sure that a handler that is being replaced
+// can't interfere with the members of its successor.
+func (p *ServerPool) syncMembers(ctx context.Context, source string, servers map[string]ServerConfiguration) (added []string, removed []string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if ctx.Err() != nil {
+		return nil, nil
+	}
+	return p.sync(source, servers)
+}
+
+func (p *ServerPool) sync(source string, servers map[string]ServerConfiguration) (added []string, removed []string) {
+	for name, worker := range p.workers {
+		if _, found := servers[name]; !found && worker.source == source {
+			p.stop(name)
+			removed = append(removed, name)
+		}
+	}
+	for name, config := range servers {
+		worker, found := p.workers[name]
+		if !found {
+			p.start(name, source, config)
+			added = append(added, name)
+			continue
+		}
+		if worker.source != source {
+			log.Printf("Ignoring %s from %s as it is already defined by %s\n", name, source, worker.source)
+			continue
+		}
+		if !reflect.DeepEqual(worker.config, config) {
+			p.shutdown(worker)
+			p.start(name, source, config)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// Get returns the configuration and source of a single server.
+func (p *ServerPool) Get(name string) (ServerConfiguration, string, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	worker, found := p.workers[name]
+	if !found {
+		return ServerConfiguration{}, "", false
+	}
+	return worker.config, worker.source, true
+}
+
+// Servers returns a copy of the configuration of all servers that were
+// added by the given source.
+func (p *ServerPool) Servers(source string) map[string]ServerConfiguration {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	result := make(map[string]ServerConfiguration)
+	for name, worker := range p.workers {
+		if worker.source == source {
+			result[name] = worker.config
+		}
+	}
+	return result
+}
+
+// Len returns the number of servers that are currently being checked.
+func (p *ServerPool) Len() int {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return len(p.workers)
+}
+
+func (p *ServerPool) stop(name string) {
+	p.shutdown(p.workers[name])
+	delete(p.workers, name)
+	statusRegistryManager.RemoveStatus(name)
+}
+
+// shutdown stops the given worker. The members of a server group are
+// removed right away so that a restarted group starts from scratch.
+func (p *ServerPool) shutdown(worker *serverWorker) {
+	if worker.cancel != nil {
+		worker.cancel()
+	}
+	if worker.job != nil {
+		p.scheduler.Remove(worker.job)
+	}
+	if worker.members != "" {
+		for name, member := range p.workers {
+			if member.source == worker.members {
+				p.stop(name)
+			}
+		}
+	}
+}
+
+// start launches the worker for a single server. Servers that discover
+// their members are handled by a group handler instead of a scheduled
+// check. Once the pool's context is done, servers are only recorded.
+func (p *ServerPool) start(name, source string, config ServerConfiguration) {
+	worker := &serverWorker{config: config, source: source}
+	p.workers[name] = worker
+	url := config.IsAliveUrl
+	if config.Discover != "" {
+		// The isAliveUrl of a group is only a template for its members.
+		url = ""
+	}
+	statusRegistryManager.SetServerInfo(name, url, config.Tags)
+	if p.ctx.Err() != nil {
+		return
+	}
+	var ctx context.Context
+	ctx, worker.cancel = context.WithCancel(p.ctx)
+	if config.Discover != "" {
+		worker.members = groupSource(name)
+		p.doneGroup.Add(1)
+		go SrvGroupHandler(ctx, name, config, p, p.doneGroup)
+		return
+	}
+	resolved := p.config.ResolveServer(config)
+	check := NewServerCheck(ctx, name, resolved, p.statusUpdateChannel)
+	// Unless the server has to be checked at a certain time, the first check
+	// is spread randomly across the jitter window so that not all servers
+	// are checked at the same time after a restart.
+	delay, jitter := check.firstDelay(time.Now())
+	if jitter {
+		delay = p.scheduler.Jitter(delay)
+	}
+	worker.job = p.scheduler.Add(check, delay)
+}
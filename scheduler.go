@@ -0,0 +1,213 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const DEFAULT_MAX_CONCURRENCY = 50
+
+// A Job is executed by the Scheduler. Run returns how long the scheduler
+// should wait before running the job again and whether that delay may be
+// extended by the scheduler's jitter. Delays that target a fixed point in
+// time (like the next slot of a cron schedule) must not be jittered.
+type Job interface {
+	Run() (time.Duration, bool)
+}
+
+// A ScheduledJob is a handle for a job that was added to the Scheduler.
+type ScheduledJob struct {
+	job     Job
+	nextRun time.Time
+	// index within the scheduler's queue or -1 if the job is currently
+	// not queued (because it is running or was removed).
+	index   int
+	removed bool
+}
+
+type jobQueue []*ScheduledJob
+
+func (q jobQueue) Len() int           { return len(q) }
+func (q jobQueue) Less(i, j int) bool { return q[i].nextRun.Before(q[j].nextRun) }
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *jobQueue) Push(x interface{}) {
+	job := x.(*ScheduledJob)
+	job.index = len(*q)
+	*q = append(*q, job)
+}
+
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	job := old[len(old)-1]
+	old[len(old)-1] = nil
+	job.index = -1
+	*q = old[:len(old)-1]
+	return job
+}
+
+// The Scheduler runs jobs at their planned time using a fixed number of
+// worker go-routines. All jobs are kept in a min-heap ordered by their next
+// run so that a single timer is enough to wait for the next due job.
+type Scheduler struct {
+	lock           sync.Mutex
+	queue          jobQueue
+	jitter         float64
+	maxConcurrency int
+	random         *rand.Rand
+	wakeup         chan struct{}
+	jobs           chan *ScheduledJob
+	workerGroup    sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler that runs at most maxConcurrency jobs at
+// the same time. Every delay returned by a job is extended by a random
+// amount of up to jitter times the delay in order to spread jobs over time.
+func NewScheduler(maxConcurrency int, jitter float64) *Scheduler {
+	if maxConcurrency <= 0 {
+		maxConcurrency = DEFAULT_MAX_CONCURRENCY
+	}
+	return &Scheduler{
+		jitter:         jitter,
+		maxConcurrency: maxConcurrency,
+		random:         rand.New(rand.NewSource(time.Now().UnixNano())),
+		wakeup:         make(chan struct{}, 1),
+		jobs:           make(chan *ScheduledJob),
+	}
+}
+
+// Start launches the dispatcher and worker go-routines. Once the given
+// context is done, running jobs are finished but no new jobs are started.
+// doneGroup is notified once all go-routines have exited.
+func (s *Scheduler) Start(ctx context.Context, doneGroup *sync.WaitGroup) {
+	s.workerGroup.Add(s.maxConcurrency)
+	for i := 0; i < s.maxConcurrency; i++ {
+		go s.worker()
+	}
+	doneGroup.Add(1)
+	go func() {
+		s.dispatch(ctx)
+		close(s.jobs)
+		s.workerGroup.Wait()
+		doneGroup.Done()
+	}()
+}
+
+// Add schedules a job to be run after the given delay.
+func (s *Scheduler) Add(job Job, delay time.Duration) *ScheduledJob {
+	scheduled := &ScheduledJob{job: job, index: -1}
+	s.lock.Lock()
+	s.schedule(scheduled, delay)
+	s.lock.Unlock()
+	return scheduled
+}
+
+// Remove makes sure that the given job is not run again. If the job is
+// currently running, it is finished but not rescheduled.
+func (s *Scheduler) Remove(scheduled *ScheduledJob) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	scheduled.removed = true
+	if scheduled.index != -1 {
+		heap.Remove(&s.queue, scheduled.index)
+	}
+}
+
+// Len returns the number of queued jobs.
+func (s *Scheduler) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.queue)
+}
+
+// Jitter returns a random duration of up to the configured jitter times
+// the given delay.
+func (s *Scheduler) Jitter(delay time.Duration) time.Duration {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.jitterLocked(delay)
+}
+
+func (s *Scheduler) jitterLocked(delay time.Duration) time.Duration {
+	if s.jitter <= 0 || delay <= 0 {
+		return 0
+	}
+	return time.Duration(s.random.Float64() * s.jitter * float64(delay))
+}
+
+// schedule has to be called with the lock being held.
+func (s *Scheduler) schedule(scheduled *ScheduledJob, delay time.Duration) {
+	if scheduled.removed {
+		return
+	}
+	scheduled.nextRun = time.Now().Add(delay)
+	heap.Push(&s.queue, scheduled)
+	// Wake up the dispatcher as the new job might be due before the one it
+	// is currently waiting for.
+	select {
+	case s.wakeup <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch waits for the next job to become due and hands it over to the
+// workers.
+func (s *Scheduler) dispatch(ctx context.Context) {
+	for {
+		s.lock.Lock()
+		var next *ScheduledJob
+		wait := time.Hour
+		if len(s.queue) != 0 {
+			wait = s.queue[0].nextRun.Sub(time.Now())
+			if wait <= 0 {
+				next = heap.Pop(&s.queue).(*ScheduledJob)
+			}
+		}
+		s.lock.Unlock()
+
+		if next != nil {
+			select {
+			case s.jobs <- next:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-s.wakeup:
+			timer.Stop()
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+func (s *Scheduler) worker() {
+	defer s.workerGroup.Done()
+	for scheduled := range s.jobs {
+		s.lock.Lock()
+		removed := scheduled.removed
+		s.lock.Unlock()
+		if removed {
+			continue
+		}
+		delay, jitter := scheduled.job.Run()
+		s.lock.Lock()
+		if jitter {
+			delay += s.jitterLocked(delay)
+		}
+		s.schedule(scheduled, delay)
+		s.lock.Unlock()
+	}
+}
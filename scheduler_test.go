@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingJob struct {
+	name   string
+	delay  time.Duration
+	jitter bool
+	runs   chan string
+}
+
+func (j *recordingJob) Run() (time.Duration, bool) {
+	j.runs <- j.name
+	return j.delay, j.jitter
+}
+
+func TestSchedulerRunsJobsInOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	doneGroup := &sync.WaitGroup{}
+	scheduler := NewScheduler(1, 0)
+	scheduler.Start(ctx, doneGroup)
+	defer func() {
+		cancel()
+		doneGroup.Wait()
+	}()
+	runs := make(chan string, 10)
+	scheduler.Add(&recordingJob{name: "late", delay: time.Hour, runs: runs}, 40*time.Millisecond)
+	scheduler.Add(&recordingJob{name: "early", delay: time.Hour, runs: runs}, 10*time.Millisecond)
+	for _, expected := range []string{"early", "late"} {
+		select {
+		case name := <-runs:
+			if name != expected {
+				t.Errorf("Expected %s to run, got %s", expected, name)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s was not run", expected)
+		}
+	}
+}
+
+func TestSchedulerRemove(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	doneGroup := &sync.WaitGroup{}
+	scheduler := NewScheduler(2, 0)
+	scheduler.Start(ctx, doneGroup)
+	defer func() {
+		cancel()
+		doneGroup.Wait()
+	}()
+	runs := make(chan string, 100)
+	job := scheduler.Add(&recordingJob{name: "fast", delay: 5 * time.Millisecond, runs: runs}, 0)
+	for i := 0; i < 3; i++ {
+		select {
+		case <-runs:
+		case <-time.After(time.Second):
+			t.Fatal("Sub-second job was not rescheduled")
+		}
+	}
+	scheduler.Remove(job)
+	// Drain a run that might have been in progress while removing the job.
+	time.Sleep(20 * time.Millisecond)
+	for len(runs) != 0 {
+		<-runs
+	}
+	time.Sleep(30 * time.Millisecond)
+	if len(runs) != 0 || scheduler.Len() != 0 {
+		t.Errorf("Removed job is still scheduled")
+	}
+}
+
+func TestSchedulerJitter(t *testing.T) {
+	scheduler := NewScheduler(1, 0.5)
+	for i := 0; i < 100; i++ {
+		if jitter := scheduler.Jitter(time.Second); jitter < 0 || jitter > 500*time.Millisecond {
+			t.Fatalf("Jitter %v out of range", jitter)
+		}
+	}
+	if jitter := NewScheduler(1, 0).Jitter(time.Second); jitter != 0 {
+		t.Errorf("Expected no jitter, got %v", jitter)
+	}
+}
+
+func TestSchedulerJitterOnlyWhenAllowed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	doneGroup := &sync.WaitGroup{}
+	scheduler := NewScheduler(2, 1)
+	scheduler.Start(ctx, doneGroup)
+	defer func() {
+		cancel()
+		doneGroup.Wait()
+	}()
+	runs := make(chan string, 2)
+	fixed := scheduler.Add(&recordingJob{name: "fixed", delay: time.Hour, runs: runs}, 0)
+	jittered := scheduler.Add(&recordingJob{name: "jittered", delay: time.Hour, jitter: true, runs: runs}, 0)
+	<-runs
+	<-runs
+	nextRun := func(job *ScheduledJob) time.Duration {
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			scheduler.lock.Lock()
+			next := job.nextRun
+			index := job.index
+			scheduler.lock.Unlock()
+			if index >= 0 {
+				return time.Until(next)
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatal("Job was not rescheduled")
+		return 0
+	}
+	if delay := nextRun(fixed); delay > time.Hour {
+		t.Errorf("Expected the delay not to be jittered, got %v", delay)
+	}
+	if delay := nextRun(jittered); delay > 2*time.Hour {
+		t.Errorf("Jittered delay %v out of range", delay)
+	}
+}
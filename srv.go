@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lookupSRV is used to resolve SRV records and can be replaced in tests.
+var lookupSRV = net.LookupSRV
+
+// srvGroup keeps track of the members of a server group that is discovered
+// through DNS SRV records.
+type srvGroup struct {
+	name    string
+	config  ServerConfiguration
+	source  string
+	members []string
+	status  string
+}
+
+// SrvGroupHandler periodically resolves the SRV record of a server group
+// and checks every returned host:port as a separate member server. The status
+// of the group itself is aggregated from the status of its members and
+// membership changes are reported through the statusUpdateChannel. The
+// handler stops once the context is done. Its members are removed by the
+// pool when the group is stopped or restarted.
+func SrvGroupHandler(ctx context.Context, name string, config ServerConfiguration, pool *ServerPool, doneGroup *sync.WaitGroup) {
+	defer doneGroup.Done()
+	group := &srvGroup{name: name, config: config, source: groupSource(name)}
+	interval := time.Duration(config.RefreshInterval)
+	if interval == 0 {
+		interval = DEFAULT_DISCOVERY_REFRESH_INTERVAL
+	}
+	memberPrefix := name + "/"
+	updates, unsubscribe := statusRegistryManager.Subscribe(func(event Event) bool {
+		return strings.HasPrefix(event.ServerName, memberPrefix)
+	}, 100)
+	defer unsubscribe()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	log.Printf("Discovering members of %s through %s\n", name, config.Discover)
+
+	group.refresh(ctx, pool)
+	for {
+		select {
+		case <-ctx.Done():
+			log.Printf("Handler for group %s received exit signal\n", name)
+			return
+		case <-ticker.C:
+			group.refresh(ctx, pool)
+		case event := <-updates:
+			if group.isMember(event.ServerName) {
+				group.updateStatus(pool.statusUpdateChannel)
+			}
+		}
+	}
+}
+
+// refresh resolves the group's members, synchronizes them with the pool and
+// reports membership changes.
+func (g *srvGroup) refresh(ctx context.Context, pool *ServerPool) {
+	servers, err := g.resolve()
+	if err != nil {
+		log.Printf("Failed to resolve members of %s: %s\n", g.name, err.Error())
+		return
+	}
+	added, removed := pool.syncMembers(ctx, g.source, servers)
+	if ctx.Err() != nil {
+		return
+	}
+	g.members = g.members[:0]
+	for member, _ := range servers {
+		g.members = append(g.members, member)
+	}
+	sort.Strings(g.members)
+	// Membership changes are only worth a notification once the group's
+	// status is known. Otherwise this is simply the initial discovery.
+	if (len(added) != 0 || len(removed) != 0) && g.status != "" {
+		var changes []string
+		if len(added) != 0 {
+			changes = append(changes, "added "+strings.Join(added, ", "))
+		}
+		if len(removed) != 0 {
+			changes = append(changes, "removed "+strings.Join(removed, ", "))
+		}
+		pool.statusUpdateChannel <- StatusUpdate{ServerName: g.name, Status: g.status, Reason: "Members changed: " + strings.Join(changes, "; ")}
+	}
+	g.updateStatus(pool.statusUpdateChannel)
+}
+
+// groupSource is the source of the servers discovered by the given group.
+func groupSource(name string) string {
+	return "group " + name
+}
+
+// resolve looks up the group's SRV record and returns a server
+// configuration for every target.
+func (g *srvGroup) resolve() (map[string]ServerConfiguration, error) {
+	_, addrs, err := lookupSRV("", "", strings.TrimPrefix(g.config.Discover, "srv:"))
+	if err != nil {
+		return nil, err
+	}
+	servers := make(map[string]ServerConfiguration)
+	for _, addr := range addrs {
+		target := net.JoinHostPort(strings.TrimSuffix(addr.Target, "."), strconv.Itoa(int(addr.Port)))
+		member, err := renderServerTemplate(g.config, serverTemplateData{Target: target})
+		if err != nil {
+			return nil, err
+		}
+		member.Discover = ""
+		servers[g.name+"/"+target] = member
+	}
+	return servers, nil
+}
+
+func (g *srvGroup) isMember(serverName string) bool {
+	idx := sort.SearchStrings(g.members, serverName)
+	return idx < len(g.members) && g.members[idx] == serverName
+}
+
+// updateStatus aggregates the status of all members and reports the
+// group's status if it has changed.
+func (g *srvGroup) updateStatus(statusUpdateChannel chan<- StatusUpdate) {
+	online := 0
+	statuses := make([]string, 0, len(g.members))
+	for _, member := range g.members {
+		status := statusRegistryManager.GetStatus(member)
+		if status == STATUS_ONLINE {
+			online++
+		}
+		statuses = append(statuses, status)
+	}
+	status := aggregateStatus(g.config.Aggregate, statuses)
+	if status == "" || status == g.status {
+		return
+	}
+	g.status = status
+	statusUpdateChannel <- StatusUpdate{ServerName: g.name, Status: status, Reason: fmt.Sprintf("%d of %d members online", online, len(g.members))}
+}
+
+// aggregateStatus determines the status of a group from the status of its
+// members. With AGGREGATE_ALL (the default) every member has to be online,
+// with AGGREGATE_ANY a single online member is enough. An empty string is
+// returned as long as the result depends on members without a status.
+func aggregateStatus(policy string, statuses []string) string {
+	if len(statuses) == 0 {
+		return STATUS_OFFLINE
+	}
+	online, offline := 0, 0
+	for _, status := range statuses {
+		switch status {
+		case STATUS_ONLINE:
+			online++
+		case STATUS_OFFLINE:
+			offline++
+		}
+	}
+	unknown := len(statuses) - online - offline
+	if policy == AGGREGATE_ANY {
+		if online != 0 {
+			return STATUS_ONLINE
+		}
+		if unknown != 0 {
+			return ""
+		}
+		return STATUS_OFFLINE
+	}
+	if offline != 0 {
+		return STATUS_OFFLINE
+	}
+	if unknown != 0 {
+		return ""
+	}
+	return STATUS_ONLINE
+}
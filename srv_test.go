@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSrvGroupResolve(t *testing.T) {
+	defer func() { lookupSRV = net.LookupSRV }()
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		if name != "_http._tcp.api.internal" {
+			t.Errorf("Unexpected lookup of %s", name)
+		}
+		return name, []*net.SRV{{Target: "a.api.internal.", Port: 8080}, {Target: "b.api.internal.", Port: 8081}}, nil
+	}
+	group := &srvGroup{name: "api", config: ServerConfiguration{Discover: "srv:_http._tcp.api.internal", IsAliveUrl: "http://{{.Target}}/health"}}
+	servers, err := group.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(servers) != 2 {
+		t.Fatalf("Expected 2 members, got %v", servers)
+	}
+	member := servers["api/b.api.internal:8081"]
+	if member.IsAliveUrl != "http://b.api.internal:8081/health" || member.Discover != "" {
+		t.Errorf("Unexpected member %v", member)
+	}
+}
+
+func TestAggregateStatus(t *testing.T) {
+	tests := []struct {
+		policy   string
+		statuses []string
+		expected string
+	}{
+		{"", nil, STATUS_OFFLINE},
+		{"", []string{STATUS_ONLINE, STATUS_ONLINE}, STATUS_ONLINE},
+		{"", []string{STATUS_ONLINE, STATUS_OFFLINE}, STATUS_OFFLINE},
+		{"", []string{STATUS_ONLINE, ""}, ""},
+		{AGGREGATE_ANY, []string{STATUS_OFFLINE, STATUS_ONLINE}, STATUS_ONLINE},
+		{AGGREGATE_ANY, []string{STATUS_OFFLINE, ""}, ""},
+		{AGGREGATE_ANY, []string{STATUS_OFFLINE, STATUS_OFFLINE}, STATUS_OFFLINE},
+	}
+	for _, test := range tests {
+		if result := aggregateStatus(test.policy, test.statuses); result != test.expected {
+			t.Errorf("aggregateStatus(%q, %v): expected %q, got %q", test.policy, test.statuses, test.expected, result)
+		}
+	}
+}
+
+func TestSrvGroupRestart(t *testing.T) {
+	defer func() { lookupSRV = net.LookupSRV }()
+	lookupSRV = func(service, proto, name string) (string, []*net.SRV, error) {
+		return name, []*net.SRV{{Target: "localhost.", Port: 1}}, nil
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	doneGroup := &sync.WaitGroup{}
+	updates := make(chan StatusUpdate)
+	go func() {
+		for range updates {
+		}
+	}()
+	pool := NewServerPool(ctx, &Configuration{}, updates, doneGroup)
+	defer func() {
+		cancel()
+		doneGroup.Wait()
+		close(updates)
+	}()
+	member := func() (ServerConfiguration, bool) {
+		config, _, found := pool.Get("g/localhost:1")
+		return config, found
+	}
+	waitForMember := func(timeout Duration) {
+		deadline := time.Now().Add(time.Second)
+		for time.Now().Before(deadline) {
+			if config, found := member(); found && config.Timeout == timeout {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("Member with timeout %v has not been discovered", timeout)
+	}
+
+	config := ServerConfiguration{Discover: "srv:_http._tcp.g", IsAliveUrl: "http://{{.Target}}/"}
+	pool.Add("g", SOURCE_CONFIG, config)
+	waitForMember(0)
+	config.Timeout = Duration(time.Second)
+	pool.Set("g", SOURCE_CONFIG, config)
+	waitForMember(config.Timeout)
+	// The previous handler must not remove the members of its successor
+	// while shutting down.
+	time.Sleep(50 * time.Millisecond)
+	if config, found := member(); !found || config.Timeout != Duration(time.Second) {
+		t.Errorf("Expected the member of the restarted group to be kept, got %v (%v)", config, found)
+	}
+
+	pool.Remove("g", SOURCE_CONFIG)
+	if _, found := member(); found {
+		t.Error("Expected the members to be removed together with the group")
+	}
+}
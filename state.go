@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zerok/statusd/Godeps/_workspace/src/gopkg.in/v2/yaml"
+)
+
+// ServerState contains everything that was changed at runtime and has to
+// survive a restart of statusd.
+type ServerState struct {
+	Servers map[string]ServerConfiguration `yaml:"servers"`
+}
+
+// LoadServerState reads the state file behind the given path. A missing
+// file is not an error but simply results in an empty state.
+func LoadServerState(path string) (*ServerState, error) {
+	state := &ServerState{Servers: make(map[string]ServerConfiguration)}
+	rawData, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(rawData, state); err != nil {
+		return nil, err
+	}
+	if state.Servers == nil {
+		state.Servers = make(map[string]ServerConfiguration)
+	}
+	return state, nil
+}
+
+// Restore adds the servers of the state to the given pool. Servers that are
+// invalid (e.g. because the state file has been edited) or already defined
+// in the configuration are skipped.
+func (s *ServerState) Restore(pool *ServerPool) {
+	for serverName, serverConfig := range s.Servers {
+		if messages := validateServerConfiguration(pool.config, serverConfig); len(messages) != 0 {
+			log.Printf("Ignoring %s from the state file: %s\n", serverName, strings.Join(messages, ", "))
+			continue
+		}
+		if err := pool.Add(serverName, SOURCE_API, serverConfig); err != nil {
+			log.Printf("Ignoring %s from the state file as it is already defined in the configuration\n", serverName)
+		}
+	}
+}
+
+// Save writes the state to the given path. The data is first written to a
+// temporary file which then replaces the original so that a crash never
+// leaves a half-written state file behind.
+func (s *ServerState) Save(path string) error {
+	rawData, err := yaml.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".statusd-state")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(rawData); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
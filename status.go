@@ -1,7 +1,9 @@
 package main
 
 import (
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -9,11 +11,26 @@ type StatusUpdate struct {
 	ServerName string
 	Status     string
 	Duration   time.Duration
+	// Reason describes why a server is offline or what else has changed.
+	Reason string
+	// Silent updates are recorded but don't trigger any notifications
+	// (e.g. outside of a server's active hours).
+	Silent bool
 }
 
 type ServerStatus struct {
 	ServerName string
 	Status     string
+	// Reason and Updated describe the last status update.
+	Reason  string
+	Updated time.Time
+	// Since is the time the server got its current status.
+	Since time.Time
+	Url   string
+	Tags  []string
+	// Details contains additional information about a server provided by
+	// the component that discovered it.
+	Details map[string]string
 }
 
 type StatusRegistry map[string]ServerStatus
@@ -32,7 +49,35 @@ func (r StatusRegistry) SetStatus(name, status string) {
 }
 
 func (r StatusRegistry) SetStatusFromUpdate(update StatusUpdate) {
+	previousStatus := r.GetStatus(update.ServerName)
 	r.SetStatus(update.ServerName, update.Status)
+	status := r[update.ServerName]
+	status.Reason = update.Reason
+	status.Updated = time.Now()
+	if update.Status != previousStatus {
+		status.Since = status.Updated
+	}
+	r[update.ServerName] = status
+}
+
+// SetServerInfo records the checked URL and the tags of a server.
+func (r StatusRegistry) SetServerInfo(name, url string, tags []string) {
+	oldStatus, found := r[name]
+	if !found {
+		oldStatus = ServerStatus{ServerName: name}
+	}
+	oldStatus.Url = url
+	oldStatus.Tags = tags
+	r[name] = oldStatus
+}
+
+func (r StatusRegistry) SetDetails(name string, details map[string]string) {
+	oldStatus, found := r[name]
+	if !found {
+		oldStatus = ServerStatus{ServerName: name}
+	}
+	oldStatus.Details = details
+	r[name] = oldStatus
 }
 
 func (r StatusRegistry) GetStatus(name string) string {
@@ -43,34 +88,179 @@ func (r StatusRegistry) GetStatus(name string) string {
 	return status.Status
 }
 
+const (
+	DEFAULT_SUBSCRIPTION_BUFFER = 10
+	// EVENT_LOG_SIZE is the number of events kept in memory for clients
+	// that resume a stream.
+	EVENT_LOG_SIZE = 1000
+)
+
+// An Event is published to all subscribers whenever a status update is
+// recorded.
+type Event struct {
+	// ID increases with every event.
+	ID uint64
+	StatusUpdate
+	// PreviousStatus is empty if the server didn't have a status before.
+	PreviousStatus string
+	// Elapsed is how long the server had its previous status.
+	Elapsed time.Duration
+	Url     string
+	Tags    []string
+	Time    time.Time
+}
+
+// An EventFilter decides whether a subscriber is interested in an event.
+type EventFilter func(Event) bool
+
+// serverFilter selects servers by name and tags. A server has to match one
+// of the given names (if any) and one of the given tags (if any).
+type serverFilter struct {
+	servers map[string]bool
+	tags    map[string]bool
+}
+
+func newServerFilter(servers, tags []string) serverFilter {
+	return serverFilter{servers: stringSet(servers), tags: stringSet(tags)}
+}
+
+func stringSet(items []string) map[string]bool {
+	if len(items) == 0 {
+		return nil
+	}
+	result := make(map[string]bool)
+	for _, item := range items {
+		result[item] = true
+	}
+	return result
+}
+
+func (f serverFilter) Matches(serverName string, tags []string) bool {
+	if f.servers != nil && !f.servers[serverName] {
+		return false
+	}
+	if f.tags == nil {
+		return true
+	}
+	for _, tag := range tags {
+		if f.tags[tag] {
+			return true
+		}
+	}
+	return false
+}
+
+type subscription struct {
+	channel chan Event
+	filter  EventFilter
+	// dropped counts the events that didn't fit into the channel.
+	dropped uint64
+	// since is the ID of the last event published before the subscription
+	// was created.
+	since uint64
+}
+
+// takeDropped returns the number of events that have been dropped since
+// the last call.
+func (s *subscription) takeDropped() uint64 {
+	return atomic.SwapUint64(&s.dropped, 0)
+}
+
 // The StatusRegistryManager is a singleton that is used for all
 // write operations to the store and that is responsible for notifying
 // any subscribers to any status changes to the StatusRegistry itself.
 type StatusRegistryManager struct {
-	registry             StatusRegistry
-	lock                 sync.RWMutex
-	notificationChannels map[chan StatusUpdate]struct{}
+	registry      StatusRegistry
+	lock          sync.RWMutex
+	subscriptions map[*subscription]struct{}
+	dropped       uint64
+	lastEventID   uint64
+	eventLog      []Event
+}
+
+// Subscribe returns a channel that receives every event matching the given
+// filter (or all events if the filter is nil). Events are never blocking
+// the publisher: if the subscriber's buffer of bufferSize events is full,
+// the event is dropped and counted (see Dropped). The returned function
+// cancels the subscription and closes the channel. It can be called
+// multiple times.
+func (m *StatusRegistryManager) Subscribe(filter EventFilter, bufferSize int) (<-chan Event, func()) {
+	sub, cancel := m.subscribe(filter, bufferSize)
+	return sub.channel, cancel
+}
+
+// subscribe works like Subscribe but returns the subscription itself so
+// that the caller can find out whether it has missed any events.
+func (m *StatusRegistryManager) subscribe(filter EventFilter, bufferSize int) (*subscription, func()) {
+	if bufferSize <= 0 {
+		bufferSize = DEFAULT_SUBSCRIPTION_BUFFER
+	}
+	sub := &subscription{channel: make(chan Event, bufferSize), filter: filter}
+	m.lock.Lock()
+	sub.since = m.lastEventID
+	m.subscriptions[sub] = struct{}{}
+	m.lock.Unlock()
+	var once sync.Once
+	return sub, func() {
+		once.Do(func() {
+			// Events are only sent while holding the lock, so the channel
+			// can safely be closed once the subscription is removed.
+			m.lock.Lock()
+			delete(m.subscriptions, sub)
+			m.lock.Unlock()
+			close(sub.channel)
+		})
+	}
 }
 
-// NotifyChange registers a channel to be notified if an entry in the
-// registry is updated
-func (m *StatusRegistryManager) NotifyChange(channel chan StatusUpdate) {
-	m.notificationChannels[channel] = struct{}{}
+// Dropped returns the number of events that could not be delivered because
+// a subscriber's buffer was full.
+func (m *StatusRegistryManager) Dropped() uint64 {
+	return atomic.LoadUint64(&m.dropped)
 }
 
-// UnnotifyChange removes the given channel from the notification list
-func (m *StatusRegistryManager) UnnotifyChange(channel chan StatusUpdate) {
-	delete(m.notificationChannels, channel)
+// EventsSince returns all logged events with an ID larger than the given
+// one. Only the last EVENT_LOG_SIZE events are kept, so the second return
+// value is false if some of the requested events are no longer available.
+func (m *StatusRegistryManager) EventsSince(id uint64) ([]Event, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	complete := true
+	if id > m.lastEventID {
+		// The ID stems from a previous run of statusd.
+		id = 0
+		complete = false
+	}
+	idx := sort.Search(len(m.eventLog), func(i int) bool { return m.eventLog[i].ID > id })
+	if idx < len(m.eventLog) && m.eventLog[idx].ID != id+1 {
+		complete = false
+	}
+	result := make([]Event, len(m.eventLog)-idx)
+	copy(result, m.eventLog[idx:])
+	return result, complete
 }
 
-func (m *StatusRegistryManager) notifyAll(update StatusUpdate) {
-	for channel, _ := range m.notificationChannels {
+// publish assigns an ID to the event and sends it to all subscribers. It
+// has to be called with the lock being held.
+func (m *StatusRegistryManager) publish(event Event) Event {
+	m.lastEventID++
+	event.ID = m.lastEventID
+	m.eventLog = append(m.eventLog, event)
+	if len(m.eventLog) > EVENT_LOG_SIZE {
+		m.eventLog = m.eventLog[len(m.eventLog)-EVENT_LOG_SIZE:]
+	}
+	for sub, _ := range m.subscriptions {
+		if sub.filter != nil && !sub.filter(event) {
+			continue
+		}
 		select {
-		case channel <- update:
+		case sub.channel <- event:
 		default:
-			// The send operation failed because the channel is most likely full. Skipping
+			atomic.AddUint64(&m.dropped, 1)
+			atomic.AddUint64(&sub.dropped, 1)
 		}
 	}
+	return event
 }
 
 func (m *StatusRegistryManager) GetStatus(serverName string) string {
@@ -79,23 +269,91 @@ func (m *StatusRegistryManager) GetStatus(serverName string) string {
 	return m.registry.GetStatus(serverName)
 }
 
-func (m *StatusRegistryManager) SetStatus(update StatusUpdate) {
+// Get returns everything known about a single server. The second return
+// value is false if the server doesn't have a status yet.
+func (m *StatusRegistryManager) Get(serverName string) (ServerStatus, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	status, found := m.registry[serverName]
+	return status, found && status.Status != ""
+}
+
+// Snapshot returns the state of every server that has a status, sorted by
+// name.
+func (m *StatusRegistryManager) Snapshot() []ServerStatus {
+	result, _ := m.snapshot()
+	return result
+}
+
+// snapshot works like Snapshot but also returns the ID of the last event
+// that is reflected in the snapshot.
+func (m *StatusRegistryManager) snapshot() ([]ServerStatus, uint64) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	result := make([]ServerStatus, 0, len(m.registry))
+	for _, status := range m.registry {
+		if status.Status != "" {
+			result = append(result, status)
+		}
+	}
+	sort.Sort(serverStatusByName(result))
+	return result, m.lastEventID
+}
+
+type serverStatusByName []ServerStatus
+
+func (s serverStatusByName) Len() int           { return len(s) }
+func (s serverStatusByName) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s serverStatusByName) Less(i, j int) bool { return s[i].ServerName < s[j].ServerName }
+
+// SetServerInfo replaces the checked URL and the tags of a server.
+// Subscribers are not notified about such changes.
+func (m *StatusRegistryManager) SetServerInfo(serverName, url string, tags []string) {
 	m.lock.Lock()
-	m.registry.SetStatusFromUpdate(update)
-	m.notifyAll(update)
+	m.registry.SetServerInfo(serverName, url, tags)
 	m.lock.Unlock()
 }
 
-func (m *StatusRegistryManager) ShowDown() {
-	for channel, _ := range m.notificationChannels {
-		close(channel)
+// SetDetails replaces the additional information known about a server.
+// Subscribers are not notified about such changes.
+func (m *StatusRegistryManager) SetDetails(serverName string, details map[string]string) {
+	m.lock.Lock()
+	m.registry.SetDetails(serverName, details)
+	m.lock.Unlock()
+}
+
+// SetStatus records the given update and publishes the resulting event,
+// which is also returned.
+func (m *StatusRegistryManager) SetStatus(update StatusUpdate) Event {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	previous := m.registry[update.ServerName]
+	m.registry.SetStatusFromUpdate(update)
+	current := m.registry[update.ServerName]
+	event := Event{
+		StatusUpdate:   update,
+		PreviousStatus: previous.Status,
+		Url:            current.Url,
+		Tags:           current.Tags,
+		Time:           current.Updated,
+	}
+	if previous.Status != "" {
+		event.Elapsed = current.Updated.Sub(previous.Since)
 	}
+	return m.publish(event)
+}
+
+// RemoveStatus forgets everything known about the given server.
+func (m *StatusRegistryManager) RemoveStatus(serverName string) {
+	m.lock.Lock()
+	delete(m.registry, serverName)
+	m.lock.Unlock()
 }
 
-func NewStatusRegistryManager() StatusRegistryManager {
-	m := StatusRegistryManager{
-		notificationChannels: make(map[chan StatusUpdate]struct{}),
-		registry:             NewStatusRegistry(),
+func NewStatusRegistryManager() *StatusRegistryManager {
+	m := &StatusRegistryManager{
+		subscriptions: make(map[*subscription]struct{}),
+		registry:      NewStatusRegistry(),
 	}
 	return m
 }
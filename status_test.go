@@ -1,6 +1,7 @@
 package main
 
 import (
+	"sync"
 	"testing"
 )
 
@@ -11,3 +12,61 @@ func TestStatusRegistrySetStatus(t *testing.T) {
 		t.Error("SetStatus didn't update the internal state of the registry")
 	}
 }
+
+func TestStatusRegistryManagerSubscribe(t *testing.T) {
+	m := NewStatusRegistryManager()
+	all, cancelAll := m.Subscribe(nil, 0)
+	web, cancelWeb := m.Subscribe(func(event Event) bool { return event.ServerName == "web" }, 1)
+	defer cancelAll()
+
+	m.SetStatus(StatusUpdate{ServerName: "db", Status: STATUS_ONLINE})
+	m.SetStatus(StatusUpdate{ServerName: "web", Status: STATUS_ONLINE})
+	m.SetStatus(StatusUpdate{ServerName: "web", Status: STATUS_OFFLINE})
+
+	if event := <-all; event.ServerName != "db" {
+		t.Errorf("Expected the first event for db, got %v", event)
+	}
+	if event := <-web; event.ServerName != "web" || event.PreviousStatus != "" {
+		t.Errorf("Unexpected event %v", event)
+	}
+	// The second event for web didn't fit into the buffer.
+	if m.Dropped() != 1 {
+		t.Errorf("Expected 1 dropped event, got %d", m.Dropped())
+	}
+
+	cancelWeb()
+	cancelWeb()
+	if _, ok := <-web; ok {
+		t.Error("Expected the channel to be closed after cancelling the subscription")
+	}
+	m.SetStatus(StatusUpdate{ServerName: "web", Status: STATUS_ONLINE})
+	<-all
+	<-all
+	if event := <-all; event.PreviousStatus != STATUS_OFFLINE {
+		t.Errorf("Expected the previous status to be offline, got %v", event)
+	}
+}
+
+func TestStatusRegistryManagerConcurrentSubscriptions(t *testing.T) {
+	m := NewStatusRegistryManager()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				m.SetStatus(StatusUpdate{ServerName: "server", Status: STATUS_ONLINE})
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				events, cancel := m.Subscribe(nil, 1)
+				cancel()
+				for _ = range events {
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/zerok/statusd/Godeps/_workspace/src/gopkg.in/v2/yaml"
+)
+
+// A ConfigurationError describes a single problem within a configuration
+// file. Line is 0 if the location of the problem couldn't be determined and
+// File is empty for configurations that weren't read from a file.
+type ConfigurationError struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (e ConfigurationError) Error() string {
+	location := e.File
+	if e.Line != 0 {
+		if location != "" {
+			location += ":"
+		}
+		location += fmt.Sprintf("line %d", e.Line)
+	}
+	if location == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", location, e.Message)
+}
+
+// ConfigurationErrors collects all problems found while validating a
+// configuration file.
+type ConfigurationErrors []ConfigurationError
+
+func (e ConfigurationErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, err := range e {
+		messages = append(messages, err.Error())
+	}
+	return "Invalid configuration:\n" + strings.Join(messages, "\n")
+}
+
+func (e ConfigurationErrors) Len() int      { return len(e) }
+func (e ConfigurationErrors) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e ConfigurationErrors) Less(i, j int) bool {
+	if e[i].File != e[j].File {
+		return e[i].File < e[j].File
+	}
+	if e[i].Line != e[j].Line {
+		return e[i].Line < e[j].Line
+	}
+	return e[i].Message < e[j].Message
+}
+
+// configurationValidator collects errors for a single configuration file and
+// resolves the path of a key (e.g. "servers.server1.timeout") to the line it
+// was defined on.
+type configurationValidator struct {
+	file   string
+	lines  map[string]int
+	errors ConfigurationErrors
+}
+
+func (v *configurationValidator) newError(path string, format string, args ...interface{}) ConfigurationError {
+	return ConfigurationError{File: v.file, Line: v.line(path), Message: fmt.Sprintf(format, args...)}
+}
+
+func (v *configurationValidator) addError(path string, format string, args ...interface{}) {
+	v.errors = append(v.errors, v.newError(path, format, args...))
+}
+
+func (v *configurationValidator) addLineError(line int, format string, args ...interface{}) {
+	v.errors = append(v.errors, ConfigurationError{File: v.file, Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// describe returns a human readable name of the validated file.
+func (v *configurationValidator) describe() string {
+	if v.file == "" {
+		return "the main configuration"
+	}
+	return v.file
+}
+
+// line returns the line of the given path or of its closest parent.
+func (v *configurationValidator) line(path string) int {
+	for path != "" {
+		if line, found := v.lines[path]; found {
+			return line
+		}
+		if idx := strings.LastIndex(path, "."); idx != -1 {
+			path = path[:idx]
+		} else {
+			path = ""
+		}
+	}
+	return 0
+}
+
+// loadConfigurationData parses a single configuration file into out. File
+// references (relative to baseDir) and environment variables are resolved
+// and keys that don't map to any field of out are reported through the
+// returned validator. Problems that prevent the data from being used at all
+// are returned as error.
+func loadConfigurationData(rawData []byte, filename string, baseDir string, out interface{}) (*configurationValidator, error) {
+	v := &configurationValidator{file: filename}
+	rawData = v.resolveFileReferences(rawData, baseDir)
+	if len(v.errors) != 0 {
+		return nil, v.errors
+	}
+	if err := yaml.Unmarshal(rawData, out); err != nil {
+		if filename != "" {
+			return nil, fmt.Errorf("%s: %s", filename, err.Error())
+		}
+		return nil, err
+	}
+	v.lines = yamlKeyLines(rawData)
+	v.interpolate("", reflect.ValueOf(out).Elem())
+	if len(v.errors) != 0 {
+		return nil, v.errors
+	}
+	var raw interface{}
+	if err := yaml.Unmarshal(rawData, &raw); err == nil {
+		v.checkKeys("", raw, reflect.TypeOf(out))
+	}
+	return v, nil
+}
+
+// validateConfiguration checks the parsed main configuration for invalid
+// values.
+func (v *configurationValidator) validateConfiguration(config *Configuration) {
+	for _, message := range validateServerDefaults(config.Defaults) {
+		v.addError("defaults", "defaults: %s", message)
+	}
+	for name, defaults := range config.Groups {
+		for _, message := range validateServerDefaults(defaults) {
+			v.addError("groups."+name, "group %s: %s", name, message)
+		}
+	}
+	v.validateServers(config, config.Servers)
+	for idx, discovery := range config.Discovery.Files {
+		path := fmt.Sprintf("discovery.files.%d", idx)
+		if len(discovery.Files) == 0 {
+			v.addError(path, "file discovery requires at least one file")
+		}
+		if discovery.Check.IsAliveUrl == "" {
+			v.addError(path, "file discovery requires a check with an isAliveUrl")
+		}
+		if discovery.RefreshInterval < 0 {
+			v.addError(path, "refreshInterval must not be negative")
+		}
+		if _, err := NewFileDiscoverer(discovery); err != nil {
+			v.addError(path, "invalid template: %s", err.Error())
+		}
+	}
+	if config.Http.PublicUrl != "" {
+		if parsed, err := url.Parse(config.Http.PublicUrl); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			v.addError("http.publicUrl", "publicUrl %q is not an absolute HTTP(S) URL", config.Http.PublicUrl)
+		}
+	}
+	v.validateNotifiers(config.Notifiers)
+	if config.Scheduler.MaxConcurrency < 0 {
+		v.addError("scheduler.maxConcurrency", "maxConcurrency must not be negative")
+	}
+	if config.Scheduler.Jitter < 0 || config.Scheduler.Jitter > 1 {
+		v.addError("scheduler.jitter", "jitter must be between 0 and 1")
+	}
+	if config.Discovery.Docker != nil && config.Discovery.Docker.RefreshInterval < 0 {
+		v.addError("discovery.docker", "refreshInterval must not be negative")
+	}
+}
+
+func (v *configurationValidator) validateNotifiers(notifiers []NotifierConfiguration) {
+	names := make(map[string]bool)
+	for idx, notifier := range notifiers {
+		path := fmt.Sprintf("notifiers.%d", idx)
+		name := notifier.Name
+		if name == "" {
+			v.addError(path, "notifier requires a name")
+			name = fmt.Sprintf("#%d", idx+1)
+		} else if names[name] {
+			v.addError(path, "notifier %s is defined more than once", name)
+		}
+		names[name] = true
+		// Only the settings block matching the notifier's type is used.
+		value := reflect.ValueOf(notifier)
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			settings := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if field.Type.Kind() == reflect.Ptr && !value.Field(i).IsNil() && settings != notifier.Type {
+				v.addError(path+"."+settings, "notifier %s is of type %s but has %s settings", name, notifier.Type, settings)
+			}
+		}
+		if _, err := NewNotifier(notifier); err != nil {
+			v.addError(path, "notifier %s: %s", name, err.Error())
+		}
+	}
+}
+
+// validateServers checks the given servers for invalid values. Defaults are
+// taken from the given configuration.
+func (v *configurationValidator) validateServers(config *Configuration, servers map[string]ServerConfiguration) {
+	for name, serverConfig := range servers {
+		for _, message := range validateServerConfiguration(config, serverConfig) {
+			v.addError("servers."+name, "server %s: %s", name, message)
+		}
+	}
+}
+
+// validateServerConfiguration returns a description of every invalid value
+// within the given server configuration. Defaults are taken from the given
+// configuration.
+func validateServerConfiguration(config *Configuration, serverConfig ServerConfiguration) []string {
+	var messages []string
+	aliveUrl := serverConfig.IsAliveUrl
+	if serverConfig.Discover != "" {
+		if !strings.HasPrefix(serverConfig.Discover, "srv:") || serverConfig.Discover == "srv:" {
+			messages = append(messages, fmt.Sprintf("discover %q is not supported (expected srv:<record>)", serverConfig.Discover))
+		}
+		if serverConfig.Aggregate != "" && serverConfig.Aggregate != AGGREGATE_ALL && serverConfig.Aggregate != AGGREGATE_ANY {
+			messages = append(messages, fmt.Sprintf("aggregate must be either %s or %s", AGGREGATE_ALL, AGGREGATE_ANY))
+		}
+		if serverConfig.RefreshInterval < 0 {
+			messages = append(messages, "refreshInterval must not be negative")
+		}
+		// The isAliveUrl is a template for the discovered members.
+		if rendered, err := renderServerTemplate(serverConfig, serverTemplateData{Target: "example.com:80"}); err != nil {
+			messages = append(messages, fmt.Sprintf("invalid template: %s", err.Error()))
+		} else {
+			aliveUrl = rendered.IsAliveUrl
+		}
+	}
+	if aliveUrl == "" {
+		messages = append(messages, "isAliveUrl is missing")
+	} else if parsed, err := url.Parse(aliveUrl); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		messages = append(messages, fmt.Sprintf("isAliveUrl %q is not an absolute HTTP(S) URL", serverConfig.IsAliveUrl))
+	}
+	if serverConfig.Group != "" {
+		if _, found := config.Groups[serverConfig.Group]; !found {
+			messages = append(messages, fmt.Sprintf("group %s is not defined", serverConfig.Group))
+		}
+	}
+	for _, tag := range serverConfig.Tags {
+		if tag == "" || strings.ContainsAny(tag, ", ") {
+			messages = append(messages, fmt.Sprintf("tag %q must not be empty or contain commas or spaces", tag))
+		}
+	}
+	if serverConfig.Timeout < 0 {
+		messages = append(messages, "timeout must not be negative")
+	}
+	if serverConfig.Delay < 0 {
+		messages = append(messages, "delay must not be negative")
+	}
+	if serverConfig.Schedule != "" && serverConfig.Delay != 0 {
+		messages = append(messages, "schedule and delay must not be used together")
+	}
+	messages = append(messages, validateBackoff(serverConfig.Backoff)...)
+	messages = append(messages, validateSchedule(serverConfig.Schedule, serverConfig.Timezone, serverConfig.ActiveHours)...)
+	resolved := config.ResolveServer(serverConfig)
+	if resolved.Delay > 0 && resolved.Timeout > resolved.Delay {
+		messages = append(messages, fmt.Sprintf("timeout (%v) is larger than delay (%v)", resolved.Timeout, resolved.Delay))
+	}
+	return messages
+}
+
+// validateServerDefaults returns a description of every invalid value
+// within a defaults block.
+func validateServerDefaults(defaults ServerDefaults) []string {
+	var messages []string
+	if defaults.Timeout < 0 {
+		messages = append(messages, "timeout must not be negative")
+	}
+	if defaults.Delay < 0 {
+		messages = append(messages, "delay must not be negative")
+	}
+	if defaults.Schedule != "" && defaults.Delay != 0 {
+		messages = append(messages, "schedule and delay must not be used together")
+	}
+	messages = append(messages, validateBackoff(defaults.Backoff)...)
+	return append(messages, validateSchedule(defaults.Schedule, defaults.Timezone, defaults.ActiveHours)...)
+}
+
+func validateSchedule(schedule, timezone string, hours *ActiveHoursConfiguration) []string {
+	var messages []string
+	location, err := loadLocation(timezone)
+	if err != nil {
+		return append(messages, fmt.Sprintf("unknown timezone %q", timezone))
+	}
+	if schedule != "" {
+		if _, err := parseCronSchedule(schedule, location); err != nil {
+			messages = append(messages, fmt.Sprintf("invalid schedule: %s", err.Error()))
+		}
+	}
+	if hours != nil {
+		if _, err := parseActiveHours(hours, location); err != nil {
+			messages = append(messages, fmt.Sprintf("invalid activeHours: %s", err.Error()))
+		}
+		if hours.Mode != "" && hours.Mode != ACTIVE_HOURS_CHECK && hours.Mode != ACTIVE_HOURS_ALERT {
+			messages = append(messages, fmt.Sprintf("activeHours mode must be either %s or %s", ACTIVE_HOURS_CHECK, ACTIVE_HOURS_ALERT))
+		}
+	}
+	return messages
+}
+
+func validateBackoff(backoff *BackoffConfiguration) []string {
+	if backoff == nil {
+		return nil
+	}
+	var messages []string
+	if backoff.Policy != "" && backoff.Policy != BACKOFF_FIXED && backoff.Policy != BACKOFF_EXPONENTIAL {
+		messages = append(messages, fmt.Sprintf("backoff policy must be either %s or %s", BACKOFF_FIXED, BACKOFF_EXPONENTIAL))
+	}
+	if backoff.Delay < 0 {
+		messages = append(messages, "backoff delay must not be negative")
+	}
+	if backoff.MaxDelay < 0 {
+		messages = append(messages, "backoff maxDelay must not be negative")
+	} else if backoff.MaxDelay > 0 && backoff.MaxDelay < backoff.Delay {
+		messages = append(messages, fmt.Sprintf("backoff maxDelay (%v) is smaller than delay (%v)", backoff.MaxDelay, backoff.Delay))
+	}
+	if backoff.Retries < 0 {
+		messages = append(messages, "backoff retries must not be negative")
+	}
+	return messages
+}
+
+// checkKeys walks the generically decoded YAML data alongside the
+// configuration type and reports every key that has no matching field.
+func (v *configurationValidator) checkKeys(path string, data interface{}, t reflect.Type) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		mapping, ok := data.(map[interface{}]interface{})
+		if !ok {
+			return
+		}
+		fields := yamlFields(t)
+		for key, value := range mapping {
+			name := fmt.Sprint(key)
+			field, found := fields[name]
+			if !found {
+				v.addError(joinPath(path, name), "unknown field %q in %s", name, describePath(path))
+				continue
+			}
+			v.checkKeys(joinPath(path, name), value, field.Type)
+		}
+	case reflect.Map:
+		mapping, ok := data.(map[interface{}]interface{})
+		if !ok {
+			return
+		}
+		for key, value := range mapping {
+			v.checkKeys(joinPath(path, fmt.Sprint(key)), value, t.Elem())
+		}
+	case reflect.Slice:
+		items, ok := data.([]interface{})
+		if !ok {
+			return
+		}
+		for idx, value := range items {
+			v.checkKeys(joinPath(path, strconv.Itoa(idx)), value, t.Elem())
+		}
+	}
+}
+
+// yamlFields maps the YAML keys of a struct to its fields.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func describePath(path string) string {
+	if path == "" {
+		return "top-level configuration"
+	}
+	return path
+}
+
+var yamlKeyPattern = regexp.MustCompile(`^("[^"]*"|'[^']*'|[^\s#"'][^:#]*?)\s*:(\s+(.*))?$`)
+
+// yamlKeyLines maps the dotted path of every key within a YAML document to
+// the (1-based) line it was defined on. Only block-style mappings and
+// sequences are taken into account which covers how statusd configuration
+// files are usually written.
+func yamlKeyLines(rawData []byte) map[string]int {
+	type frame struct {
+		indent int
+		path   string
+		isItem bool
+	}
+	lines := make(map[string]int)
+	itemCounts := make(map[string]int)
+	var stack []frame
+	blockScalarIndent := -1
+	scanner := bufio.NewScanner(bytes.NewReader(rawData))
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		text := scanner.Text()
+		content := strings.TrimLeft(text, " ")
+		indent := len(text) - len(content)
+		if blockScalarIndent != -1 {
+			if content == "" || indent > blockScalarIndent {
+				continue
+			}
+			blockScalarIndent = -1
+		}
+		if content == "" || strings.HasPrefix(content, "#") || content == "---" {
+			continue
+		}
+		if content == "-" || strings.HasPrefix(content, "- ") {
+			for len(stack) > 0 && (stack[len(stack)-1].indent > indent || (stack[len(stack)-1].indent == indent && stack[len(stack)-1].isItem)) {
+				stack = stack[:len(stack)-1]
+			}
+			parent := ""
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1].path
+			}
+			itemPath := joinPath(parent, strconv.Itoa(itemCounts[parent]))
+			itemCounts[parent]++
+			lines[itemPath] = lineNo
+			stack = append(stack, frame{indent: indent, path: itemPath, isItem: true})
+			rest := strings.TrimLeft(strings.TrimPrefix(content, "-"), " ")
+			indent += len(content) - len(rest)
+			content = rest
+		}
+		match := yamlKeyPattern.FindStringSubmatch(content)
+		if match == nil {
+			continue
+		}
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+		parent := ""
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1].path
+		}
+		path := joinPath(parent, strings.Trim(match[1], `"'`))
+		if _, found := lines[path]; !found {
+			lines[path] = lineNo
+		}
+		stack = append(stack, frame{indent: indent, path: path})
+		if value := match[3]; strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			blockScalarIndent = indent
+		}
+	}
+	return lines
+}
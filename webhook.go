@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"text/template"
+	"time"
+)
+
+const DEFAULT_WEBHOOK_SIGNATURE_HEADER = "X-Statusd-Signature"
+
+// webhookPayload is the JSON document sent by webhook notifiers without a
+// template. Exec notifiers pass it to their command as well.
+type webhookPayload struct {
+	Server         string    `json:"server"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previousStatus,omitempty"`
+	Reason         string    `json:"reason,omitempty"`
+	Duration       Duration  `json:"duration"`
+	Downtime       Duration  `json:"downtime,omitempty"`
+	Url            string    `json:"url,omitempty"`
+	Tags           []string  `json:"tags,omitempty"`
+	Time           time.Time `json:"time"`
+	DashboardUrl   string    `json:"dashboardUrl,omitempty"`
+}
+
+func newWebhookPayload(message Message) webhookPayload {
+	return webhookPayload{
+		Server:         message.ServerName,
+		Status:         message.Status,
+		PreviousStatus: message.PreviousStatus,
+		Reason:         message.Reason,
+		Duration:       Duration(message.Duration),
+		Downtime:       Duration(message.Downtime),
+		Url:            message.Url,
+		Tags:           message.Tags,
+		Time:           message.Time,
+		DashboardUrl:   message.DashboardUrl,
+	}
+}
+
+// The WebhookNotifier sends every status change to an arbitrary URL. The
+// body is either a fixed JSON document or rendered from a template that has
+// access to the Message. If a secret is configured, the body is signed using
+// HMAC-SHA256 and the signature is sent as "sha256=<hex>".
+type WebhookNotifier struct {
+	config   WebhookNotifierConfiguration
+	template *template.Template
+}
+
+var webhookTemplateFuncs = template.FuncMap{
+	// json encodes a value so that it can safely be embedded into a JSON
+	// document, e.g. {"text": {{json .Text}}}.
+	"json": func(value interface{}) (string, error) {
+		data, err := json.Marshal(value)
+		return string(data), err
+	},
+}
+
+func NewWebhookNotifier(config WebhookNotifierConfiguration) (*WebhookNotifier, error) {
+	if parsed, err := url.Parse(config.Url); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("url %q is not an absolute HTTP(S) URL", config.Url)
+	}
+	notifier := &WebhookNotifier{config: config}
+	if config.Template != "" {
+		tmpl, err := template.New("webhook").Funcs(webhookTemplateFuncs).Option("missingkey=error").Parse(config.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template: %s", err.Error())
+		}
+		notifier.template = tmpl
+	}
+	return notifier, nil
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, message Message) error {
+	body, err := n.render(message)
+	if err != nil {
+		return err
+	}
+	contentType := n.config.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	headers := make(map[string]string)
+	for name, value := range n.config.Headers {
+		headers[name] = value
+	}
+	if n.config.Secret != "" {
+		signatureHeader := n.config.SignatureHeader
+		if signatureHeader == "" {
+			signatureHeader = DEFAULT_WEBHOOK_SIGNATURE_HEADER
+		}
+		headers[signatureHeader] = signWebhookBody(n.config.Secret, body)
+	}
+	_, err = post(ctx, n.config.Url, contentType, headers, bytes.NewReader(body))
+	return err
+}
+
+func (n *WebhookNotifier) render(message Message) ([]byte, error) {
+	if n.template == nil {
+		return json.Marshal(newWebhookPayload(message))
+	}
+	var body bytes.Buffer
+	if err := n.template.Execute(&body, message); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// signWebhookBody returns the HMAC-SHA256 signature of the body in the
+// format "sha256=<hex>".
+func signWebhookBody(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	notifier, err := NewWebhookNotifier(WebhookNotifierConfiguration{
+		Url:     server.URL,
+		Headers: map[string]string{"X-Team": "ops"},
+		Secret:  "s3cret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := Message{ServerName: "web", Status: STATUS_OFFLINE, PreviousStatus: STATUS_ONLINE, Reason: "timeout", Duration: 2 * time.Second}
+	if err := notifier.Notify(context.Background(), message); err != nil {
+		t.Fatal(err)
+	}
+	var payload webhookPayload
+	if err := json.Unmarshal(body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Server != "web" || payload.Status != STATUS_OFFLINE || payload.PreviousStatus != STATUS_ONLINE || payload.Reason != "timeout" || payload.Duration != Duration(2*time.Second) {
+		t.Errorf("Unexpected payload %s", body)
+	}
+	if header.Get("Content-Type") != "application/json" || header.Get("X-Team") != "ops" {
+		t.Errorf("Unexpected headers %v", header)
+	}
+	if signature := header.Get(DEFAULT_WEBHOOK_SIGNATURE_HEADER); signature != signWebhookBody("s3cret", body) {
+		t.Errorf("Unexpected signature %s", signature)
+	}
+}
+
+func TestWebhookNotifierTemplate(t *testing.T) {
+	var body []byte
+	var header http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	notifier, err := NewWebhookNotifier(WebhookNotifierConfiguration{
+		Url:         server.URL,
+		Template:    `{"text": {{json .Title}}, "online": {{.Online}}}`,
+		ContentType: "application/vnd.bot+json",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := notifier.Notify(context.Background(), Message{ServerName: `"web"`, Status: STATUS_ONLINE}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := `{"text": "\"web\" is now online", "online": true}`; string(body) != expected {
+		t.Errorf("Expected %s, got %s", expected, body)
+	}
+	if header.Get("Content-Type") != "application/vnd.bot+json" || header.Get(DEFAULT_WEBHOOK_SIGNATURE_HEADER) != "" {
+		t.Errorf("Unexpected headers %v", header)
+	}
+}
+
+func TestNewWebhookNotifierValidation(t *testing.T) {
+	for _, config := range []WebhookNotifierConfiguration{
+		{},
+		{Url: "ftp://example.com/"},
+		{Url: "https://example.com/", Template: "{{.Unknown"},
+	} {
+		if _, err := NewWebhookNotifier(config); err == nil {
+			t.Errorf("Expected %v to be invalid", config)
+		}
+	}
+}